@@ -5,6 +5,7 @@ package main
 import (
 	. "boltdb_benchmarks/strategy"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -26,6 +27,8 @@ type BenchmarkResult struct {
 	Duration     time.Duration
 	StorageBytes int64
 	RecordCount  int
+	// AllocsPerRecord is only measured for FieldSum.
+	AllocsPerRecord float64
 }
 
 // Generate test data
@@ -67,15 +70,18 @@ func getDBSize(dbPath string) (int64, error) {
 	return info.Size(), nil
 }
 
-// Run benchmark for a specific strategy
-func runBenchmark(
-	strategy *StrategyVariant,
-	users []*UserInfo,
+// Run benchmark for a specific strategy. FieldSum sums field and Update
+// sets it to value, which must have the field's type.
+func runBenchmark[T any](
+	strategy *Variant[T],
+	records []*T,
 	readIDs []int64,
 	updateIDs []int64,
 	runs int,
+	field string,
+	value interface{},
 ) []BenchmarkResult {
-	recordCount := len(users)
+	recordCount := len(records)
 	var results []BenchmarkResult
 
 	for run := range runs {
@@ -88,9 +94,13 @@ func runBenchmark(
 		}
 
 		// SETUP & WRITE ALL
-		strategy.Setup(db)
+		if err := strategy.Setup(db); err != nil {
+			log.Fatalf("%s: Setup: %v", strategy.Name(), err)
+		}
 		t0 := time.Now()
-		strategy.WriteAll(db, users)
+		if err := strategy.WriteAll(db, records); err != nil {
+			log.Fatalf("%s: WriteAll: %v", strategy.Name(), err)
+		}
 		writeTotal := time.Since(t0)
 		db.Close()
 		storageSize, _ := getDBSize(dbPath)
@@ -115,17 +125,22 @@ func runBenchmark(
 			log.Printf("ReadMany error: %v", err)
 		}
 
-		// 3) field sum over all
+		// 3) field sum over all, counting heap allocations outside the timing
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		mallocs := mem.Mallocs
 		t0 = time.Now()
-		if _, err := strategy.ReadFieldSum(db, "balance", recordCount); err != nil {
+		if _, err := strategy.ReadFieldSum(db, field, recordCount); err != nil {
 			log.Printf("FieldSum error: %v", err)
 		}
 		fieldSumTotal := time.Since(t0)
+		runtime.ReadMemStats(&mem)
+		fieldSumAllocs := float64(mem.Mallocs-mallocs) / float64(recordCount)
 
 		// 4) many single updates
 		t0 = time.Now()
 		for _, id := range updateIDs {
-			if err := strategy.UpdateField(db, id, "balance", 12345.67); err != nil {
+			if err := strategy.UpdateField(db, id, field, value); err != nil {
 				log.Printf("Update error: %v", err)
 			}
 		}
@@ -148,11 +163,11 @@ func runBenchmark(
 		}
 
 		results = append(results,
-			BenchmarkResult{base.Strategy, base.Bulk, "Write", perWrite, base.StorageBytes, base.RecordCount},
-			BenchmarkResult{base.Strategy, base.Bulk, "Read", perRead, base.StorageBytes, base.RecordCount},
-			BenchmarkResult{base.Strategy, base.Bulk, "ReadMany", perReadMany, base.StorageBytes, base.RecordCount},
-			BenchmarkResult{base.Strategy, base.Bulk, "FieldSum", perFieldSum, base.StorageBytes, base.RecordCount},
-			BenchmarkResult{base.Strategy, base.Bulk, "Update", perUpdate, base.StorageBytes, base.RecordCount},
+			BenchmarkResult{base.Strategy, base.Bulk, "Write", perWrite, base.StorageBytes, base.RecordCount, 0},
+			BenchmarkResult{base.Strategy, base.Bulk, "Read", perRead, base.StorageBytes, base.RecordCount, 0},
+			BenchmarkResult{base.Strategy, base.Bulk, "ReadMany", perReadMany, base.StorageBytes, base.RecordCount, 0},
+			BenchmarkResult{base.Strategy, base.Bulk, "FieldSum", perFieldSum, base.StorageBytes, base.RecordCount, fieldSumAllocs},
+			BenchmarkResult{base.Strategy, base.Bulk, "Update", perUpdate, base.StorageBytes, base.RecordCount, 0},
 		)
 	}
 	return results
@@ -175,18 +190,21 @@ func calculateAverages(results []BenchmarkResult) []BenchmarkResult {
 	for k, slice := range grouped {
 		var sumDur time.Duration
 		var sumBytes int64
+		var sumAllocs float64
 		for _, r := range slice {
 			sumDur += r.Duration
 			sumBytes += r.StorageBytes
+			sumAllocs += r.AllocsPerRecord
 		}
 		n := time.Duration(len(slice))
 		avgResults = append(avgResults, BenchmarkResult{
-			Strategy:     k.strat,
-			Bulk:         k.bulk,
-			Operation:    k.op,
-			Duration:     sumDur / n,
-			StorageBytes: sumBytes / int64(len(slice)),
-			RecordCount:  k.rc,
+			Strategy:        k.strat,
+			Bulk:            k.bulk,
+			Operation:       k.op,
+			Duration:        sumDur / n,
+			StorageBytes:    sumBytes / int64(len(slice)),
+			RecordCount:     k.rc,
+			AllocsPerRecord: sumAllocs / float64(len(slice)),
 		})
 	}
 	return avgResults
@@ -225,11 +243,11 @@ func printResults(results []BenchmarkResult) {
 		subset := byCount[rc]
 		fmt.Printf("\n--- %d Records ---\n", rc)
 		fmt.Printf(
-			"%-15s %-8s %-10s %-10s %-10s %-10s %-10s %-12s\n",
+			"%-15s %-8s %-10s %-10s %-10s %-10s %-10s %-10s %-12s\n",
 			"Strategy", "Insert", "Write(μs)", "Read(μs)",
-			"FldSum(μs)", "Update(μs)", "ReadMany(μs)", "Storage(KB)",
+			"FldSum(μs)", "FldSum(al)", "Update(μs)", "ReadMany(μs)", "Storage(KB)",
 		)
-		fmt.Println(strings.Repeat("-", 15+8+10*5+12))
+		fmt.Println(strings.Repeat("-", 15+8+10*6+12))
 
 		// Build op → result map for each (strategy, bulk)
 		type key struct {
@@ -266,6 +284,7 @@ func printResults(results []BenchmarkResult) {
 			write := toUs(ops["Write"].Duration)
 			read := toUs(ops["Read"].Duration)
 			fs := toUs(ops["FieldSum"].Duration)
+			fsAllocs := ops["FieldSum"].AllocsPerRecord
 			up := toUs(ops["Update"].Duration)
 			many := toUs(ops["ReadMany"].Duration)
 			sizeKB := float64(ops["Write"].StorageBytes) / 1024.0
@@ -275,10 +294,102 @@ func printResults(results []BenchmarkResult) {
 				insertMode = "Bulk"
 			}
 			fmt.Printf(
-				"%-15s %-8s %-10.2f %-10.2f %-10.2f %-10.2f %-10.2f %-12.2f\n",
-				v.strat, insertMode, write, read, fs, up, many, sizeKB,
+				"%-15s %-8s %-10.2f %-10.2f %-10.2f %-10.2f %-10.2f %-10.2f %-12.2f\n",
+				v.strat, insertMode, write, read, fs, fsAllocs, up, many, sizeKB,
+			)
+		}
+	}
+}
+
+// unwrapper is implemented by decorators, which report their overhead
+// against the strategy they wrap.
+type unwrapper interface {
+	Unwrap() StorageStrategy
+}
+
+// Print the overhead of each decorator over the strategy it wraps, per
+// operation and insert mode. baselines maps a decorator's name to the name
+// of the wrapped strategy.
+func printOverhead(results []BenchmarkResult, baselines map[string]string) {
+	type key struct {
+		strat string
+		bulk  bool
+		op    string
+		rc    int
+	}
+	byKey := make(map[key]BenchmarkResult)
+	for _, r := range results {
+		byKey[key{r.Strategy, r.Bulk, r.Operation, r.RecordCount}] = r
+	}
+
+	type variant struct {
+		strat string
+		bulk  bool
+		rc    int
+	}
+	var variants []variant
+	for k := range byKey {
+		if _, ok := baselines[k.strat]; ok && k.op == "Write" {
+			variants = append(variants, variant{k.strat, k.bulk, k.rc})
+		}
+	}
+	if len(variants) == 0 {
+		return
+	}
+	sort.Slice(variants, func(i, j int) bool {
+		a, b := variants[i], variants[j]
+		if a.rc != b.rc {
+			return a.rc < b.rc
+		}
+		if a.strat != b.strat {
+			return a.strat < b.strat
+		}
+		return !a.bulk && b.bulk
+	})
+
+	pct := func(d, base float64) string {
+		if base == 0 {
+			return "n/a"
+		}
+		return fmt.Sprintf("%+.1f%%", (d-base)/base*100)
+	}
+
+	rc := -1
+	for _, v := range variants {
+		if v.rc != rc {
+			rc = v.rc
+			fmt.Printf("\n--- Overhead vs unwrapped, %d Records ---\n", rc)
+			fmt.Printf(
+				"%-15s %-8s %-10s %-10s %-10s %-10s %-10s %-12s %-12s\n",
+				"Strategy", "Insert", "Write", "Read",
+				"FldSum", "Update", "ReadMany", "Storage", "Baseline",
+			)
+			fmt.Println(strings.Repeat("-", 15+8+10*5+12*2))
+		}
+		base := baselines[v.strat]
+		if _, ok := byKey[key{base, v.bulk, "Write", v.rc}]; !ok {
+			continue
+		}
+		phase := func(op string) string {
+			return pct(
+				float64(byKey[key{v.strat, v.bulk, op, v.rc}].Duration),
+				float64(byKey[key{base, v.bulk, op, v.rc}].Duration),
 			)
 		}
+		storage := pct(
+			float64(byKey[key{v.strat, v.bulk, "Write", v.rc}].StorageBytes),
+			float64(byKey[key{base, v.bulk, "Write", v.rc}].StorageBytes),
+		)
+
+		insertMode := "Single"
+		if v.bulk {
+			insertMode = "Bulk"
+		}
+		fmt.Printf(
+			"%-15s %-8s %-10s %-10s %-10s %-10s %-10s %-12s %-12s\n",
+			v.strat, insertMode, phase("Write"), phase("Read"), phase("FieldSum"),
+			phase("Update"), phase("ReadMany"), storage, base,
+		)
 	}
 }
 
@@ -296,7 +407,7 @@ func writeCSV(path string, results []BenchmarkResult) error {
 	// Header
 	w.Write([]string{
 		"Strategy", "Insert", "RecordCount",
-		"Operation", "Duration_us", "StorageBytes",
+		"Operation", "Duration_us", "StorageBytes", "AllocsPerRecord",
 	})
 
 	for _, r := range results {
@@ -311,6 +422,7 @@ func writeCSV(path string, results []BenchmarkResult) error {
 			r.Operation,
 			fmt.Sprintf("%.0f", float64(r.Duration.Nanoseconds())/1e3),
 			strconv.FormatInt(r.StorageBytes, 10),
+			strconv.FormatFloat(r.AllocsPerRecord, 'f', 2, 64),
 		}
 		w.Write(rec)
 	}
@@ -323,13 +435,22 @@ func main() {
 	fmt.Println("BBolt Storage Strategy Benchmark")
 	fmt.Println("=================================")
 
-	baseStrategies := []StorageStrategy{
-		&JSONStrategy{},
-		&GOBStrategy{},
-		&BinaryStrategy{},
-		&BinaryWithNamesStrategy{},
-		&MultiKVStrategy{},
-		&NestedBucketStrategy{},
+	key, err := LoadEncryptionKey()
+	if errors.Is(err, ErrNoEncryptionKey) {
+		log.Printf("%v; using an ephemeral key", err)
+		key, err = NewEphemeralKey()
+	}
+	if err != nil {
+		log.Fatalf("failed to load encryption key: %v", err)
+	}
+
+	baseStrategies := Catalog(key)
+
+	baselines := make(map[string]string)
+	for _, base := range baseStrategies {
+		if w, ok := base.(unwrapper); ok {
+			baselines[base.Name()] = w.Unwrap().Name()
+		}
 	}
 
 	// Create variants for individual and bulk writes
@@ -364,7 +485,7 @@ func main() {
 		for _, strat := range strategies {
 			fmt.Printf("Benchmarking %s (bulk=%v) with %d records...\n",
 				strat.Strategy.Name(), strat.Bulk, rc)
-			res := runBenchmark(strat, subset, readIDs, updateIDs, benchmarkRuns)
+			res := runBenchmark(strat, subset, readIDs, updateIDs, benchmarkRuns, "balance", 12345.67)
 			allResults = append(allResults, res...)
 		}
 	}
@@ -372,6 +493,7 @@ func main() {
 	// Calculate and print averages
 	averages := calculateAverages(allResults)
 	printResults(averages)
+	printOverhead(averages, baselines)
 	if err := writeCSV("benchmark_results.csv", averages); err != nil {
 		log.Fatalf("failed to write CSV: %v", err)
 	}
@@ -0,0 +1,627 @@
+// Command bingen generates the hand-rolled Binary codec of a struct.
+//
+// It is meant to be run by go generate from the package that declares the
+// struct:
+//
+//	//go:generate go run ../cmd/bingen -type UserInfo
+//
+// and writes <type>_bingen.go next to it. The layout is the one of
+// BinaryStrategy and StructCodec.AppendBinary: the ID, then every string and
+// []byte field as an int32 length and its bytes, then the fixed-size fields,
+// little-endian, each group in field order. Field names follow the same rule
+// as StructCodec: the `bolt` tag, then the `json` tag, then the Go name, with
+// "-" and unexported fields skipped and the ",id" option or the name "id"
+// marking the ID.
+//
+// The decoders return the typed errors of the package, as the hand-written
+// Binary decoder does: ErrTruncated, *LengthError, ErrBadBool for a bool
+// byte other than 0 or 1, and ErrTrailingData.
+//
+// For a type T with lower-cased name t, the generated file contains:
+//
+//	appendTBinary(buf []byte, v *T) []byte
+//	decodeTBinary(data []byte, v *T) error
+//	tBinarySize(v *T) int
+//	t<Field>Offset(data []byte) (int, error)  // one per field
+//	setTField(v *T, name string, value interface{}) error
+//	isTNumber(name string) bool
+//	readTNumber(data []byte, name string) (float64, error)
+//	patchTField(data []byte, name string, value interface{}) ([]byte, bool, error)
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("bingen: ")
+
+	typeName := flag.String("type", "", "name of the struct type to generate the codec for (required)")
+	output := flag.String("output", "", "output file name; default <type>_bingen.go")
+	flag.Parse()
+	if *typeName == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+	out := *output
+	if out == "" {
+		out = strings.ToLower(*typeName) + "_bingen.go"
+	}
+	out = filepath.Join(dir, out)
+
+	pkg, st, err := findStruct(dir, *typeName, out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fields, err := structFields(*typeName, st)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(pkg, *typeName, fields)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// findStruct parses the non-test Go files of dir, except the output file,
+// and returns the package name and the named struct type.
+func findStruct(dir, typeName, skip string) (string, *ast.StructType, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", nil, err
+	}
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || filepath.Clean(path) == filepath.Clean(skip) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, decl := range file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != typeName {
+					continue
+				}
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					return "", nil, fmt.Errorf("%s is not a struct", typeName)
+				}
+				if ts.TypeParams != nil {
+					return "", nil, fmt.Errorf("%s: generic types are not supported", typeName)
+				}
+				return file.Name.Name, st, nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("type %s not found in %s", typeName, dir)
+}
+
+// field is one encoded struct field.
+type field struct {
+	goName string // Go field name
+	name   string // stored field name
+	typ    string // Go type as written, e.g. "int32" or "[]byte"
+	kind   string // underlying basic kind: "int32", "float64", "bool", "string", "bytes", ...
+	size   int    // fixed width, or 0 for strings and []byte
+	offset int    // offset among the fixed fields, or index among the variable ones
+}
+
+func (f *field) isInt() bool {
+	return strings.HasPrefix(f.kind, "int") || strings.HasPrefix(f.kind, "uint")
+}
+
+func (f *field) isFloat() bool { return strings.HasPrefix(f.kind, "float") }
+
+func (f *field) isNumber() bool { return f.isInt() || f.isFloat() }
+
+// kinds maps the supported basic types to their fixed width.
+var kinds = map[string]int{
+	"int8": 1, "uint8": 1, "byte": 1, "bool": 1,
+	"int16": 2, "uint16": 2,
+	"int32": 4, "uint32": 4, "float32": 4,
+	"int64": 8, "uint64": 8, "float64": 8,
+	"string": 0,
+}
+
+// layout is the Binary order of the fields of a struct.
+type layout struct {
+	id        *field   // nil if the struct has no ID field
+	variable  []*field // strings and []byte, in field order
+	fixed     []*field // fixed-size fields other than the ID, in field order
+	fixedSize int
+}
+
+func structFields(typeName string, st *ast.StructType) (*layout, error) {
+	var all []*field
+	var byOpt, byName *field
+	seen := map[string]bool{}
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", typeName)
+		}
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			s, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(s)
+		}
+		for _, ident := range f.Names {
+			name, opts := fieldName(ident.Name, tag)
+			if name == "" {
+				continue
+			}
+			typ, kind, err := fieldType(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", typeName, ident.Name, err)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("%s: duplicate field name %q", typeName, name)
+			}
+			seen[name] = true
+			fd := &field{goName: ident.Name, name: name, typ: typ, kind: kind, size: kinds[kind]}
+			if opts == "id" {
+				byOpt = fd
+			} else if name == "id" {
+				byName = fd
+			}
+			all = append(all, fd)
+		}
+	}
+
+	l := &layout{id: byOpt}
+	if l.id == nil {
+		l.id = byName
+	}
+	if l.id != nil && !l.id.isInt() {
+		return nil, fmt.Errorf("%s: id field %s is not an integer", typeName, l.id.goName)
+	}
+	for _, f := range all {
+		switch {
+		case f == l.id:
+		case f.size == 0:
+			f.offset = len(l.variable)
+			l.variable = append(l.variable, f)
+		default:
+			f.offset = l.fixedSize
+			l.fixedSize += f.size
+			l.fixed = append(l.fixed, f)
+		}
+	}
+	return l, nil
+}
+
+// fieldName mirrors the naming rule of the strategy package's StructCodec.
+func fieldName(goName string, tag reflect.StructTag) (name, opts string) {
+	if !token.IsExported(goName) {
+		return "", ""
+	}
+	name, opts, _ = strings.Cut(tag.Get("bolt"), ",")
+	if name == "" {
+		name, _, _ = strings.Cut(tag.Get("json"), ",")
+	}
+	if name == "-" {
+		return "", ""
+	}
+	if name == "" {
+		name = goName
+	}
+	return name, opts
+}
+
+// fieldType returns the type of a field as written and its basic kind.
+func fieldType(expr ast.Expr) (typ, kind string, err error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if _, ok := kinds[t.Name]; ok {
+			kind = t.Name
+			if kind == "byte" {
+				kind = "uint8"
+			}
+			return t.Name, kind, nil
+		}
+	case *ast.ArrayType:
+		if elt, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && (elt.Name == "byte" || elt.Name == "uint8") {
+			return "[]" + elt.Name, "bytes", nil
+		}
+	}
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), expr)
+	return "", "", fmt.Errorf("unsupported type %s", buf.String())
+}
+
+func generate(pkg, typeName string, l *layout) ([]byte, error) {
+	g := &generator{typ: typeName, prefix: lowerFirst(typeName), l: l}
+	g.file(pkg)
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v\n%s", err, g.buf.Bytes())
+	}
+	return src, nil
+}
+
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
+
+type generator struct {
+	buf    bytes.Buffer
+	typ    string // struct type name, e.g. UserInfo
+	prefix string // lower-cased type name, e.g. userInfo
+	l      *layout
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// fn names a generated function: fn("append", "Binary") is appendUserInfoBinary.
+func (g *generator) fn(verb, noun string) string {
+	if verb == "" {
+		return g.prefix + noun
+	}
+	return verb + g.typ + noun
+}
+
+func (g *generator) all() []*field {
+	var fs []*field
+	if g.l.id != nil {
+		fs = append(fs, g.l.id)
+	}
+	fs = append(fs, g.l.variable...)
+	return append(fs, g.l.fixed...)
+}
+
+func (g *generator) file(pkg string) {
+	usesMath, usesBinary := false, len(g.l.variable) > 0
+	for _, f := range g.all() {
+		usesMath = usesMath || f.isFloat()
+		usesBinary = usesBinary || f.size > 1
+	}
+	imports := []string{"fmt"}
+	if usesBinary {
+		imports = append(imports, "encoding/binary")
+	}
+	if usesMath {
+		imports = append(imports, "math")
+	}
+	sort.Strings(imports)
+
+	g.printf("// Code generated by bingen -type %s; DO NOT EDIT.\n\n", g.typ)
+	g.printf("package %s\n\nimport (\n", pkg)
+	for _, imp := range imports {
+		g.printf("\t%q\n", imp)
+	}
+	g.printf(")\n\n")
+
+	idSize := 0
+	if g.l.id != nil {
+		idSize = g.l.id.size
+	}
+	g.printf("const (\n")
+	g.printf("\t%s = %d // bytes before the first string\n", g.fn("", "BinaryIDSize"), idSize)
+	g.printf("\t%s = %d // bytes of the fixed-size fields after the strings\n", g.fn("", "BinaryFixedSize"), g.l.fixedSize)
+	g.printf(")\n\n")
+
+	g.size()
+	g.append()
+	g.decode()
+	g.offsets()
+	g.set()
+	g.numbers()
+	g.patch()
+}
+
+// put returns the statement appending the value expr of field f to buf.
+func put(f *field, expr string) string {
+	switch f.kind {
+	case "bool":
+		return fmt.Sprintf("if %s {\nbuf = append(buf, 1)\n} else {\nbuf = append(buf, 0)\n}", expr)
+	case "int8", "uint8":
+		return fmt.Sprintf("buf = append(buf, byte(%s))", expr)
+	case "int16", "uint16":
+		return fmt.Sprintf("buf = binary.LittleEndian.AppendUint16(buf, uint16(%s))", expr)
+	case "int32", "uint32":
+		return fmt.Sprintf("buf = binary.LittleEndian.AppendUint32(buf, uint32(%s))", expr)
+	case "int64", "uint64":
+		return fmt.Sprintf("buf = binary.LittleEndian.AppendUint64(buf, uint64(%s))", expr)
+	case "float32":
+		return fmt.Sprintf("buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(%s))", expr)
+	case "float64":
+		return fmt.Sprintf("buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(%s))", expr)
+	}
+	panic("bingen: no fixed encoding for " + f.kind)
+}
+
+// get returns the expression reading field f from data[off:], which must be
+// long enough.
+func get(f *field, data, off string) string {
+	at := data + "[" + off + ":]"
+	switch f.kind {
+	case "bool":
+		return fmt.Sprintf("%s[%s] != 0", data, off)
+	case "int8", "uint8":
+		return fmt.Sprintf("%s(%s[%s])", f.typ, data, off)
+	case "int16", "uint16":
+		return fmt.Sprintf("%s(binary.LittleEndian.Uint16(%s))", f.typ, at)
+	case "int32", "uint32":
+		return fmt.Sprintf("%s(binary.LittleEndian.Uint32(%s))", f.typ, at)
+	case "int64", "uint64":
+		return fmt.Sprintf("%s(binary.LittleEndian.Uint64(%s))", f.typ, at)
+	case "float32":
+		return fmt.Sprintf("math.Float32frombits(binary.LittleEndian.Uint32(%s))", at)
+	case "float64":
+		return fmt.Sprintf("math.Float64frombits(binary.LittleEndian.Uint64(%s))", at)
+	}
+	panic("bingen: no fixed decoding for " + f.kind)
+}
+
+// checkFixedSize returns the statements that return, after ret, an error
+// unless the expression have equals want: ErrTruncated if it is less, and
+// ErrTrailingData if it is more.
+func checkFixedSize(typ, have, want, ret string) string {
+	excess := have + "-" + want
+	if strings.Contains(want, "+") {
+		excess = have + "-(" + want + ")"
+	}
+	return fmt.Sprintf("if %[1]s < %[2]s {\n%[3]sfmt.Errorf(\"%[4]s: read fixed fields: %%w\", ErrTruncated)\n}\n"+
+		"if %[1]s > %[2]s {\n%[3]sfmt.Errorf(\"%[4]s: %%d bytes after the last field: %%w\", %[5]s, ErrTrailingData)\n}",
+		have, want, ret, typ, excess)
+}
+
+func (g *generator) size() {
+	g.printf("// %s returns the length of the Binary encoding of v.\n", g.fn("", "BinarySize"))
+	g.printf("func %s(v *%s) int {\n", g.fn("", "BinarySize"), g.typ)
+	g.printf("n := %s + %d + %s\n", g.fn("", "BinaryIDSize"), 4*len(g.l.variable), g.fn("", "BinaryFixedSize"))
+	for _, f := range g.l.variable {
+		g.printf("n += len(v.%s)\n", f.goName)
+	}
+	g.printf("return n\n}\n\n")
+}
+
+func (g *generator) append() {
+	name := g.fn("append", "Binary")
+	g.printf("// %s appends the Binary encoding of v to buf.\n", name)
+	g.printf("func %s(buf []byte, v *%s) []byte {\n", name, g.typ)
+	if g.l.id != nil {
+		g.printf("%s\n", put(g.l.id, "v."+g.l.id.goName))
+	}
+	for _, f := range g.l.variable {
+		g.printf("buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.%s)))\n", f.goName)
+		g.printf("buf = append(buf, v.%s...)\n", f.goName)
+	}
+	for _, f := range g.l.fixed {
+		g.printf("%s\n", put(f, "v."+f.goName))
+	}
+	g.printf("return buf\n}\n\n")
+}
+
+func (g *generator) decode() {
+	name := g.fn("decode", "Binary")
+	g.printf("// %s decodes a Binary encoding into v. It checks every length\n", name)
+	g.printf("// against data and rejects trailing bytes.\n")
+	g.printf("func %s(data []byte, v *%s) error {\n", name, g.typ)
+	if g.l.id != nil {
+		g.printf("if len(data) < %s {\n", g.fn("", "BinaryIDSize"))
+		g.printf("return fmt.Errorf(\"%s: read %s: %%w\", ErrTruncated)\n}\n", g.typ, g.l.id.name)
+		g.printf("v.%s = %s\n", g.l.id.goName, get(g.l.id, "data", "0"))
+		g.printf("data = data[%s:]\n", g.fn("", "BinaryIDSize"))
+	}
+	if len(g.l.variable) > 0 {
+		g.printf("var n int64\n")
+	}
+	for _, f := range g.l.variable {
+		g.printf("if len(data) < 4 {\n")
+		g.printf("return fmt.Errorf(\"%s: read %s: %%w\", ErrTruncated)\n}\n", g.typ, f.name)
+		g.printf("n = int64(int32(binary.LittleEndian.Uint32(data)))\n")
+		g.printf("if n < 0 || n > int64(len(data)-4) {\n")
+		g.printf("return fmt.Errorf(\"%s: %%w\", &LengthError{Field: %q, Length: n, Remaining: len(data) - 4})\n}\n",
+			g.typ, f.name)
+		if f.kind == "bytes" {
+			g.printf("v.%s = append(%s(nil), data[4:4+n]...)\n", f.goName, f.typ)
+		} else {
+			g.printf("v.%s = string(data[4 : 4+n])\n", f.goName)
+		}
+		g.printf("data = data[4+n:]\n")
+	}
+	g.printf("%s\n", checkFixedSize(g.typ, "len(data)", g.fn("", "BinaryFixedSize"), "return "))
+	for _, f := range g.l.fixed {
+		if f.kind == "bool" {
+			g.printf("if data[%d] > 1 {\n", f.offset)
+			g.printf("return fmt.Errorf(\"%s: read %s: %%w\", ErrBadBool)\n}\n", g.typ, f.name)
+		}
+	}
+	for _, f := range g.l.fixed {
+		g.printf("v.%s = %s\n", f.goName, get(f, "data", strconv.Itoa(f.offset)))
+	}
+	g.printf("return nil\n}\n\n")
+}
+
+func (g *generator) offsets() {
+	strOff := g.fn("", "StringOffset")
+	fixedOff := g.fn("", "FixedOffset")
+	if len(g.l.variable) == 0 {
+		g.printf("// %s returns the offset of the fixed-size fields, checking\n", fixedOff)
+		g.printf("// that data has the size of the record.\n")
+		g.printf("func %s(data []byte) (int, error) {\n", fixedOff)
+		g.printf("%s\n", checkFixedSize(g.typ, "len(data)", g.fn("", "BinaryIDSize")+"+"+g.fn("", "BinaryFixedSize"), "return 0, "))
+		g.printf("return %s, nil\n}\n\n", g.fn("", "BinaryIDSize"))
+	} else {
+		g.stringOffsets(strOff, fixedOff)
+	}
+
+	if g.l.id != nil {
+		name := g.fn("", g.l.id.goName+"Offset")
+		g.printf("// %s returns the offset of %s in a Binary encoding.\n", name, g.l.id.goName)
+		g.printf("func %s(data []byte) (int, error) {\n", name)
+		g.printf("if len(data) < %s {\n", g.fn("", "BinaryIDSize"))
+		g.printf("return 0, fmt.Errorf(\"%s: read %s: %%w\", ErrTruncated)\n}\n", g.typ, g.l.id.name)
+		g.printf("return 0, nil\n}\n\n")
+	}
+	for _, f := range g.l.variable {
+		name := g.fn("", f.goName+"Offset")
+		g.printf("// %s returns the offset of the length prefix of %s.\n", name, f.goName)
+		g.printf("func %s(data []byte) (int, error) { return %s(data, %d) }\n\n", name, strOff, f.offset)
+	}
+	for _, f := range g.l.fixed {
+		name := g.fn("", f.goName+"Offset")
+		g.printf("// %s returns the offset of %s in a Binary encoding.\n", name, f.goName)
+		if f.offset == 0 {
+			g.printf("func %s(data []byte) (int, error) { return %s(data) }\n\n", name, fixedOff)
+			continue
+		}
+		g.printf("func %s(data []byte) (int, error) {\n", name)
+		g.printf("off, err := %s(data)\n", fixedOff)
+		g.printf("return off + %d, err\n}\n\n", f.offset)
+	}
+}
+
+// stringOffsets emits the offset helpers of a layout with variable-size
+// fields, which have to be walked.
+func (g *generator) stringOffsets(strOff, fixedOff string) {
+	g.printf("// %s returns the offset of the length prefix of the i-th\n", strOff)
+	g.printf("// variable-size field, or of the fixed-size fields for i == %d.\n", len(g.l.variable))
+	g.printf("func %s(data []byte, i int) (int, error) {\n", strOff)
+	g.printf("off := %s\n", g.fn("", "BinaryIDSize"))
+	g.printf("if len(data) < off {\n")
+	g.printf("return 0, fmt.Errorf(\"%s: %%w\", ErrTruncated)\n}\n", g.typ)
+	g.printf("for ; i > 0; i-- {\n")
+	g.printf("if len(data)-off < 4 {\n")
+	g.printf("return 0, fmt.Errorf(\"%s: %%w\", ErrTruncated)\n}\n", g.typ)
+	g.printf("n := int64(int32(binary.LittleEndian.Uint32(data[off:])))\n")
+	g.printf("if n < 0 || n > int64(len(data)-off-4) {\n")
+	g.printf("return 0, fmt.Errorf(\"%s: %%w\", &LengthError{Field: \"string\", Length: n, Remaining: len(data) - off - 4})\n}\n", g.typ)
+	g.printf("off += 4 + int(n)\n}\n")
+	g.printf("return off, nil\n}\n\n")
+
+	g.printf("// %s returns the offset of the fixed-size fields after the\n", fixedOff)
+	g.printf("// strings, checking that exactly %s bytes follow.\n", g.fn("", "BinaryFixedSize"))
+	g.printf("func %s(data []byte) (int, error) {\n", fixedOff)
+	g.printf("off, err := %s(data, %d)\n", strOff, len(g.l.variable))
+	g.printf("if err != nil {\nreturn 0, err\n}\n")
+	g.printf("%s\n", checkFixedSize(g.typ, "len(data)-off", g.fn("", "BinaryFixedSize"), "return 0, "))
+	g.printf("return off, nil\n}\n\n")
+}
+
+func (g *generator) set() {
+	name := g.fn("set", "Field")
+	g.printf("// %s assigns value to the named field; value must have the\n// field's exact type. The ID, which is also the key, is not updatable.\n", name)
+	g.printf("func %s(v *%s, name string, value interface{}) error {\n", name, g.typ)
+	g.printf("var ok bool\nswitch name {\n")
+	for _, f := range g.all() {
+		if f == g.l.id {
+			continue
+		}
+		g.printf("case %q:\nv.%s, ok = value.(%s)\n", f.name, f.goName, f.typ)
+	}
+	g.printf("default:\nreturn fmt.Errorf(\"field %%q is not updatable\", name)\n}\n")
+	g.printf("if !ok {\nreturn fmt.Errorf(\"%%s: unexpected value type %%T\", name, value)\n}\n")
+	g.printf("return nil\n}\n\n")
+}
+
+func (g *generator) numbers() {
+	var nums []*field
+	for _, f := range g.all() {
+		if f.isNumber() {
+			nums = append(nums, f)
+		}
+	}
+
+	is := g.fn("is", "Number")
+	g.printf("// %s reports whether the named field is numeric and can be summed.\n", is)
+	g.printf("func %s(name string) bool {\n", is)
+	if len(nums) == 0 {
+		g.printf("return false\n}\n\n")
+	} else {
+		g.printf("switch name {\ncase ")
+		for i, f := range nums {
+			if i > 0 {
+				g.printf(", ")
+			}
+			g.printf("%q", f.name)
+		}
+		g.printf(":\nreturn true\n}\nreturn false\n}\n\n")
+	}
+
+	read := g.fn("read", "Number")
+	g.printf("// %s reads a numeric field as float64 straight from a Binary\n// encoding, without decoding the rest of the record.\n", read)
+	g.printf("func %s(data []byte, name string) (float64, error) {\n", read)
+	if len(nums) > 0 {
+		g.printf("switch name {\n")
+		for _, f := range nums {
+			g.printf("case %q:\n", f.name)
+			g.printf("off, err := %s(data)\n", g.fn("", f.goName+"Offset"))
+			g.printf("if err != nil {\nreturn 0, err\n}\n")
+			if f.kind == "float64" {
+				g.printf("return %s, nil\n", get(f, "data", "off"))
+			} else {
+				g.printf("return float64(%s), nil\n", get(f, "data", "off"))
+			}
+		}
+		g.printf("}\n")
+	}
+	g.printf("return 0, fmt.Errorf(\"cannot sum field %%q\", name)\n}\n\n")
+}
+
+func (g *generator) patch() {
+	name := g.fn("patch", "Field")
+	g.printf("// %s returns a copy of a Binary encoding with a fixed-size field\n", name)
+	g.printf("// set to value, leaving data untouched. It reports false for fields whose\n")
+	g.printf("// size can change, which need a full re-encode.\n")
+	g.printf("func %s(data []byte, name string, value interface{}) ([]byte, bool, error) {\n", name)
+	g.printf("var v %s\n", g.typ)
+	g.printf("var off int\nvar err error\nswitch name {\n")
+	for _, f := range g.all() {
+		if f.size == 0 {
+			continue
+		}
+		g.printf("case %q:\noff, err = %s(data)\n", f.name, g.fn("", f.goName+"Offset"))
+	}
+	g.printf("default:\n")
+	g.printf("if err := %s(&v, name, value); err != nil {\nreturn nil, false, err\n}\n", g.fn("set", "Field"))
+	g.printf("return nil, false, nil\n}\n")
+	g.printf("if err != nil {\nreturn nil, false, err\n}\n")
+	g.printf("if err := %s(&v, name, value); err != nil {\nreturn nil, false, err\n}\n", g.fn("set", "Field"))
+	g.printf("buf := make([]byte, off, len(data))\ncopy(buf, data)\n")
+	g.printf("switch name {\n")
+	for _, f := range g.all() {
+		if f.size == 0 {
+			continue
+		}
+		g.printf("case %q:\n%s\n", f.name, put(f, "v."+f.goName))
+	}
+	g.printf("}\n")
+	g.printf("return append(buf, data[len(buf):]...), true, nil\n}\n")
+}
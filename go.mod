@@ -2,7 +2,6 @@ module boltdb_benchmarks
 
 go 1.24.3
 
-require (
-	go.etcd.io/bbolt v1.4.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
-)
+require go.etcd.io/bbolt v1.4.0
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,611 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// 18. Avro-style schema strategy
+//
+// The writer schema is stored once in the "schemas" bucket, keyed by its
+// CRC-64-AVRO fingerprint, and every value uses Avro's single-object
+// encoding: the marker C3 01, the little-endian fingerprint, then the
+// fields in schema order without names or tags. Reads resolve the schema a
+// record was written with against the strategy's schema: fields the reader
+// does not know are skipped, fields the writer did not have get their
+// default, and int, long and float values are promoted as Avro allows.
+type AvroStrategy struct {
+	// Fields is the record schema, in order. Defaults to every UserInfo
+	// field with zero defaults.
+	Fields []AvroField
+
+	once   sync.Once
+	schema *avroSchema
+	err    error
+
+	mu    sync.Mutex
+	plans map[uint64]*avroPlan // resolved writer schemas, by fingerprint
+}
+
+// AvroField is a schema field. Name is a UserInfo json field name; the Avro
+// type follows from the Go type. Default must have the field's Go type, or
+// be nil for the zero value.
+type AvroField struct {
+	Name    string
+	Default interface{}
+}
+
+// avroSchema is a schema in use: the fields as userFields indexes, their
+// canonical form and its fingerprint.
+type avroSchema struct {
+	fields      []int
+	defaults    []interface{} // by userFields index
+	canonical   []byte
+	fingerprint uint64
+}
+
+// avroStep decodes one writer field. field is the userFields index the
+// value goes to, or -1 to skip it.
+type avroStep struct {
+	writerType string
+	field      int
+}
+
+// avroPlan decodes values written with one writer schema.
+type avroPlan struct {
+	steps    []avroStep
+	defaults []int // reader fields the writer does not have
+}
+
+var avroMarker = []byte{0xc3, 0x01}
+
+func (s *AvroStrategy) Name() string { return "Avro" }
+
+func avroType(tag byte) string {
+	switch tag {
+	case tagInt64:
+		return "long"
+	case tagInt32:
+		return "int"
+	case tagFloat32:
+		return "float"
+	case tagFloat64:
+		return "double"
+	case tagBool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// crc64AvroTable is the lookup table of the CRC-64-AVRO fingerprint.
+var crc64AvroTable = func() (table [256]uint64) {
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = fp>>1 ^ crc64AvroEmpty&-(fp&1)
+		}
+		table[i] = fp
+	}
+	return table
+}()
+
+const crc64AvroEmpty = 0xc15d213aa4d7a795
+
+func avroFingerprint(data []byte) uint64 {
+	fp := uint64(crc64AvroEmpty)
+	for _, b := range data {
+		fp = fp>>8 ^ crc64AvroTable[byte(fp)^b]
+	}
+	return fp
+}
+
+// avroCanonical returns the Parsing Canonical Form of a UserInfo record
+// with the given fields.
+func avroCanonical(fields []int) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"name":"UserInfo","type":"record","fields":[`)
+	for n, i := range fields {
+		if n > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"name":"%s","type":"%s"}`, userFields[i].name, avroType(userFields[i].tag))
+	}
+	sb.WriteString(`]}`)
+	return []byte(sb.String())
+}
+
+// readerSchema validates Fields and builds the strategy's schema.
+func (s *AvroStrategy) readerSchema() (*avroSchema, error) {
+	s.once.Do(func() {
+		fields := s.Fields
+		if fields == nil {
+			for i := range userFields {
+				fields = append(fields, AvroField{Name: userFields[i].name})
+			}
+		}
+		schema := &avroSchema{defaults: make([]interface{}, len(userFields))}
+		seen := map[string]bool{}
+		for _, f := range fields {
+			i, ok := lookupUserField(f.Name)
+			if !ok {
+				s.err = fmt.Errorf("avro: unknown field %q", f.Name)
+				return
+			}
+			if seen[f.Name] {
+				s.err = fmt.Errorf("avro: duplicate field %q", f.Name)
+				return
+			}
+			seen[f.Name] = true
+			if f.Default != nil {
+				if err := setUserField(&UserInfo{}, f.Name, f.Default); err != nil {
+					s.err = fmt.Errorf("avro: default: %w", err)
+					return
+				}
+			}
+			schema.fields = append(schema.fields, i)
+			schema.defaults[i] = f.Default
+		}
+		schema.canonical = avroCanonical(schema.fields)
+		schema.fingerprint = avroFingerprint(schema.canonical)
+		s.schema = schema
+	})
+	return s.schema, s.err
+}
+
+func (schema *avroSchema) has(name string) bool {
+	for _, i := range schema.fields {
+		if userFields[i].name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AvroStrategy) Setup(db *bbolt.DB) error {
+	schema, err := s.readerSchema()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("users_avro")); err != nil {
+			return err
+		}
+		schemas, err := tx.CreateBucketIfNotExists([]byte("schemas"))
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(nil, schema.fingerprint)
+		if schemas.Get(key) != nil {
+			return nil
+		}
+		return schemas.Put(key, schema.canonical)
+	})
+}
+
+// avroPromotable reports whether a writer type can be read as reader type.
+func avroPromotable(writer, reader string) bool {
+	if writer == reader {
+		return true
+	}
+	switch writer {
+	case "int":
+		return reader == "long" || reader == "float" || reader == "double"
+	case "long":
+		return reader == "float" || reader == "double"
+	case "float":
+		return reader == "double"
+	}
+	return false
+}
+
+// resolve matches a writer schema, in canonical form, against the reader.
+func (s *AvroStrategy) resolve(reader *avroSchema, canonical []byte) (*avroPlan, error) {
+	var writer struct {
+		Fields []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(canonical, &writer); err != nil {
+		return nil, fmt.Errorf("avro: parse writer schema: %w", err)
+	}
+
+	inReader := make([]bool, len(userFields))
+	for _, i := range reader.fields {
+		inReader[i] = true
+	}
+	inWriter := make([]bool, len(userFields))
+	plan := &avroPlan{}
+	for _, wf := range writer.Fields {
+		step := avroStep{writerType: wf.Type, field: -1}
+		if i, ok := lookupUserField(wf.Name); ok && inReader[i] {
+			if !avroPromotable(wf.Type, avroType(userFields[i].tag)) {
+				return nil, fmt.Errorf("avro: field %s: cannot read %s as %s",
+					wf.Name, wf.Type, avroType(userFields[i].tag))
+			}
+			step.field = i
+			inWriter[i] = true
+		}
+		plan.steps = append(plan.steps, step)
+	}
+	for _, i := range reader.fields {
+		if !inWriter[i] {
+			plan.defaults = append(plan.defaults, i)
+		}
+	}
+	return plan, nil
+}
+
+// plan returns the decoding plan for records written with fingerprint,
+// loading the writer schema from the schemas bucket the first time.
+func (s *AvroStrategy) plan(tx *bbolt.Tx, fingerprint uint64) (*avroPlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if plan, ok := s.plans[fingerprint]; ok {
+		return plan, nil
+	}
+	reader, err := s.readerSchema()
+	if err != nil {
+		return nil, err
+	}
+	canonical := tx.Bucket([]byte("schemas")).Get(binary.BigEndian.AppendUint64(nil, fingerprint))
+	if canonical == nil {
+		return nil, fmt.Errorf("avro: unknown schema %016x", fingerprint)
+	}
+	plan, err := s.resolve(reader, canonical)
+	if err != nil {
+		return nil, err
+	}
+	if s.plans == nil {
+		s.plans = make(map[uint64]*avroPlan)
+	}
+	s.plans[fingerprint] = plan
+	return plan, nil
+}
+
+func appendAvroLong(buf []byte, v int64) []byte {
+	return binary.AppendUvarint(buf, zigzag(v))
+}
+
+func appendAvroString(buf []byte, str string) []byte {
+	return append(appendAvroLong(buf, int64(len(str))), str...)
+}
+
+// appendAvroField writes the value of userFields[i].
+func appendAvroField(buf []byte, i int, user *UserInfo) []byte {
+	switch userFields[i].name {
+	case "id":
+		return appendAvroLong(buf, user.ID)
+	case "username":
+		return appendAvroString(buf, user.Username)
+	case "email":
+		return appendAvroString(buf, user.Email)
+	case "first_name":
+		return appendAvroString(buf, user.FirstName)
+	case "last_name":
+		return appendAvroString(buf, user.LastName)
+	case "age":
+		return appendAvroLong(buf, int64(user.Age))
+	case "height":
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(user.Height))
+	case "weight":
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(user.Weight))
+	case "balance":
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(user.Balance))
+	case "is_active":
+		if user.IsActive {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case "created_at":
+		return appendAvroLong(buf, user.CreatedAt)
+	case "updated_at":
+		return appendAvroLong(buf, user.UpdatedAt)
+	case "login_count":
+		return appendAvroLong(buf, int64(user.LoginCount))
+	case "score":
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(user.Score))
+	case "description":
+		return appendAvroString(buf, user.Description)
+	}
+	panic("avro: unhandled field " + userFields[i].name)
+}
+
+func (s *AvroStrategy) encodeAvro(user *UserInfo) ([]byte, error) {
+	schema, err := s.readerSchema()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 80+len(user.Username)+len(user.Email)+
+		len(user.FirstName)+len(user.LastName)+len(user.Description))
+	buf = append(buf, avroMarker...)
+	buf = binary.LittleEndian.AppendUint64(buf, schema.fingerprint)
+	for _, i := range schema.fields {
+		buf = appendAvroField(buf, i, user)
+	}
+	return buf, nil
+}
+
+// avroHeader splits a value into its schema fingerprint and body.
+func avroHeader(data []byte) (uint64, []byte, error) {
+	if len(data) < 10 || data[0] != avroMarker[0] || data[1] != avroMarker[1] {
+		return 0, nil, fmt.Errorf("avro: missing single-object header")
+	}
+	return binary.LittleEndian.Uint64(data[2:]), data[10:], nil
+}
+
+// avroValue is a decoded value of any Avro primitive type.
+type avroValue struct {
+	i   int64
+	f   float64
+	str []byte
+}
+
+// readAvroValue decodes one value of the given type from the start of data
+// and returns the rest.
+func readAvroValue(data []byte, typ string) (avroValue, []byte, error) {
+	var v avroValue
+	switch typ {
+	case "int", "long":
+		u, n := binary.Uvarint(data)
+		if n <= 0 {
+			return v, nil, fmt.Errorf("avro: bad %s", typ)
+		}
+		v.i = unzigzag(u)
+		v.f = float64(v.i)
+		return v, data[n:], nil
+	case "float":
+		if len(data) < 4 {
+			return v, nil, fmt.Errorf("avro: truncated float")
+		}
+		v.f = float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+		return v, data[4:], nil
+	case "double":
+		if len(data) < 8 {
+			return v, nil, fmt.Errorf("avro: truncated double")
+		}
+		v.f = math.Float64frombits(binary.LittleEndian.Uint64(data))
+		return v, data[8:], nil
+	case "boolean":
+		if len(data) < 1 {
+			return v, nil, fmt.Errorf("avro: truncated boolean")
+		}
+		if data[0] > 1 {
+			return v, nil, fmt.Errorf("avro: boolean byte %d: %w", data[0], ErrBadBool)
+		}
+		v.i = int64(data[0])
+		return v, data[1:], nil
+	case "string", "bytes":
+		u, n := binary.Uvarint(data)
+		size := unzigzag(u)
+		if n <= 0 || size < 0 || size > int64(len(data)-n) {
+			return v, nil, fmt.Errorf("avro: bad string length")
+		}
+		v.str = data[n : n+int(size)]
+		return v, data[n+int(size):], nil
+	}
+	return v, nil, fmt.Errorf("avro: unsupported type %q", typ)
+}
+
+func avroInt32(v int64, what string) (int32, error) {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return 0, fmt.Errorf("avro: %s: %d overflows int32", what, v)
+	}
+	return int32(v), nil
+}
+
+// setAvroField assigns a decoded value to userFields[i].
+func setAvroField(user *UserInfo, i int, v avroValue) (err error) {
+	switch userFields[i].name {
+	case "id":
+		user.ID = v.i
+	case "username":
+		user.Username = string(v.str)
+	case "email":
+		user.Email = string(v.str)
+	case "first_name":
+		user.FirstName = string(v.str)
+	case "last_name":
+		user.LastName = string(v.str)
+	case "age":
+		user.Age, err = avroInt32(v.i, "age")
+	case "height":
+		user.Height = float32(v.f)
+	case "weight":
+		user.Weight = float32(v.f)
+	case "balance":
+		user.Balance = v.f
+	case "is_active":
+		user.IsActive = v.i != 0
+	case "created_at":
+		user.CreatedAt = v.i
+	case "updated_at":
+		user.UpdatedAt = v.i
+	case "login_count":
+		user.LoginCount, err = avroInt32(v.i, "login_count")
+	case "score":
+		user.Score = v.f
+	case "description":
+		user.Description = string(v.str)
+	}
+	return err
+}
+
+// decodeAvro decodes data into user. If only is a userFields index, just
+// that field is assigned.
+func (s *AvroStrategy) decodeAvro(tx *bbolt.Tx, data []byte, user *UserInfo, only int) error {
+	fingerprint, body, err := avroHeader(data)
+	if err != nil {
+		return err
+	}
+	plan, err := s.plan(tx, fingerprint)
+	if err != nil {
+		return err
+	}
+	for _, step := range plan.steps {
+		var v avroValue
+		if v, body, err = readAvroValue(body, step.writerType); err != nil {
+			return err
+		}
+		if step.field >= 0 && (only < 0 || step.field == only) {
+			if err := setAvroField(user, step.field, v); err != nil {
+				return err
+			}
+		}
+	}
+	if len(body) != 0 {
+		return fmt.Errorf("avro: %d trailing bytes", len(body))
+	}
+	for _, i := range plan.defaults {
+		if d := s.schema.defaults[i]; d != nil && (only < 0 || i == only) {
+			if err := setUserField(user, userFields[i].name, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *AvroStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	data, err := s.encodeAvro(user)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_avro"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, data)
+	})
+}
+
+func (s *AvroStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_avro"))
+		for _, user := range users {
+			data, err := s.encodeAvro(user)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *AvroStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_avro"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user = &UserInfo{}
+		return s.decodeAvro(tx, data, user, -1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *AvroStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_avro")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user := &UserInfo{}
+			if err := s.decodeAvro(tx, v, user, -1); err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *AvroStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	schema, err := s.readerSchema()
+	if err != nil {
+		return err
+	}
+	if !schema.has(fieldName) {
+		return fmt.Errorf("avro: field %q is not in the schema", fieldName)
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_avro"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var user UserInfo
+		if err := s.decodeAvro(tx, data, &user, -1); err != nil {
+			return err
+		}
+		if err := setUserField(&user, fieldName, value); err != nil {
+			return err
+		}
+		// The record is rewritten with the current schema.
+		newData, err := s.encodeAvro(&user)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *AvroStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	schema, err := s.readerSchema()
+	if err != nil {
+		return 0, err
+	}
+	if !schema.has(fieldName) {
+		return 0, fmt.Errorf("avro: field %q is not in the schema", fieldName)
+	}
+	idx, _ := lookupUserField(fieldName)
+	var sum float64
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_avro")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			var user UserInfo
+			if err := s.decodeAvro(tx, v, &user, idx); err != nil {
+				return err
+			}
+			n, err := userFieldNumber(&user, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *AvroStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_avro"), corrupt)
+}
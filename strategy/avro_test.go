@@ -0,0 +1,30 @@
+package strategy_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "boltdb_benchmarks/strategy"
+	"go.etcd.io/bbolt"
+)
+
+func TestAvroSumOutsideSchema(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "avro.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &AvroStrategy{Fields: []AvroField{{Name: "id"}, {Name: "balance"}}}
+	if err := s.Setup(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteMany(db, []*UserInfo{{ID: 1, Balance: 2, Score: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := s.ReadFieldSum(db, "balance", 1); err != nil || sum != 2 {
+		t.Errorf("ReadFieldSum(balance) = %v, %v; want 2", sum, err)
+	}
+	if sum, err := s.ReadFieldSum(db, "score", 1); err == nil {
+		t.Errorf("ReadFieldSum(score) = %v, want an error: score is not in the schema", sum)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"go.etcd.io/bbolt"
+	"math"
 )
 
 // 3. Binary encoding strategy (values only)
@@ -49,51 +50,109 @@ func (s *BinaryStrategy) encodeBinary(user *UserInfo) []byte {
 	return buf.Bytes()
 }
 
-func (s *BinaryStrategy) decodeBinary(data []byte) (*UserInfo, error) {
-	buf := bytes.NewReader(data)
-	user := &UserInfo{}
+// binaryReader consumes a Binary or Binary+Names record, remembering the
+// first error. Lengths are checked against the remaining input before
+// anything is allocated.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.data = nil
+}
 
-	binary.Read(buf, binary.LittleEndian, &user.ID)
+// next returns the next n bytes, or n zero bytes once the input is
+// exhausted.
+func (r *binaryReader) next(field string, n int) []byte {
+	if len(r.data) < n {
+		r.fail(fmt.Errorf("read %s: %w", field, ErrTruncated))
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
 
-	// Read string fields
-	var strLen int32
+func (r *binaryReader) int32(field string) int32 {
+	return int32(binary.LittleEndian.Uint32(r.next(field, 4)))
+}
 
-	binary.Read(buf, binary.LittleEndian, &strLen)
-	usernameBytes := make([]byte, strLen)
-	buf.Read(usernameBytes)
-	user.Username = string(usernameBytes)
+func (r *binaryReader) int64(field string) int64 {
+	return int64(binary.LittleEndian.Uint64(r.next(field, 8)))
+}
 
-	binary.Read(buf, binary.LittleEndian, &strLen)
-	emailBytes := make([]byte, strLen)
-	buf.Read(emailBytes)
-	user.Email = string(emailBytes)
+func (r *binaryReader) float32(field string) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(r.next(field, 4)))
+}
 
-	binary.Read(buf, binary.LittleEndian, &strLen)
-	firstNameBytes := make([]byte, strLen)
-	buf.Read(firstNameBytes)
-	user.FirstName = string(firstNameBytes)
+func (r *binaryReader) float64(field string) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(r.next(field, 8)))
+}
 
-	binary.Read(buf, binary.LittleEndian, &strLen)
-	lastNameBytes := make([]byte, strLen)
-	buf.Read(lastNameBytes)
-	user.LastName = string(lastNameBytes)
+func (r *binaryReader) bool(field string) bool {
+	b := r.next(field, 1)[0]
+	if b > 1 {
+		r.fail(fmt.Errorf("read %s: %w", field, ErrBadBool))
+	}
+	return b != 0
+}
 
-	binary.Read(buf, binary.LittleEndian, &strLen)
-	descBytes := make([]byte, strLen)
-	buf.Read(descBytes)
-	user.Description = string(descBytes)
+// end fails if anything is left after the last field.
+func (r *binaryReader) end() {
+	if len(r.data) != 0 {
+		r.fail(fmt.Errorf("%d bytes after the last field: %w", len(r.data), ErrTrailingData))
+	}
+}
+
+// bytes reads an int32 length prefix and that many bytes.
+func (r *binaryReader) bytes(field string) []byte {
+	n := r.int32(field)
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || int64(n) > int64(len(r.data)) {
+		r.fail(&LengthError{Field: field, Length: int64(n), Remaining: len(r.data)})
+		return nil
+	}
+	return r.next(field, int(n))
+}
+
+func (r *binaryReader) string(field string) string {
+	return string(r.bytes(field))
+}
+
+func (s *BinaryStrategy) decodeBinary(data []byte) (*UserInfo, error) {
+	r := &binaryReader{data: data}
+	user := &UserInfo{}
+
+	user.ID = r.int64("id")
+
+	// Read string fields
+	user.Username = r.string("username")
+	user.Email = r.string("email")
+	user.FirstName = r.string("first_name")
+	user.LastName = r.string("last_name")
+	user.Description = r.string("description")
 
 	// Read fixed-size fields
-	binary.Read(buf, binary.LittleEndian, &user.Age)
-	binary.Read(buf, binary.LittleEndian, &user.Height)
-	binary.Read(buf, binary.LittleEndian, &user.Weight)
-	binary.Read(buf, binary.LittleEndian, &user.Balance)
-	binary.Read(buf, binary.LittleEndian, &user.IsActive)
-	binary.Read(buf, binary.LittleEndian, &user.CreatedAt)
-	binary.Read(buf, binary.LittleEndian, &user.UpdatedAt)
-	binary.Read(buf, binary.LittleEndian, &user.LoginCount)
-	binary.Read(buf, binary.LittleEndian, &user.Score)
+	user.Age = r.int32("age")
+	user.Height = r.float32("height")
+	user.Weight = r.float32("weight")
+	user.Balance = r.float64("balance")
+	user.IsActive = r.bool("is_active")
+	user.CreatedAt = r.int64("created_at")
+	user.UpdatedAt = r.int64("updated_at")
+	user.LoginCount = r.int32("login_count")
+	user.Score = r.float64("score")
+	r.end()
 
+	if r.err != nil {
+		return nil, fmt.Errorf("binary: %w", r.err)
+	}
 	return user, nil
 }
 
@@ -192,6 +251,9 @@ func (s *BinaryStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, v
 }
 
 func (s *BinaryStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
 	var sum float64
 	err := db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("users_binary"))
@@ -218,3 +280,15 @@ func (s *BinaryStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int)
 	})
 	return sum, err
 }
+
+func (s *BinaryStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeBinary(user), nil
+}
+
+func (s *BinaryStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeBinary(data)
+}
+
+func (s *BinaryStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_binary"), corrupt)
+}
@@ -0,0 +1,159 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// 20. Generated binary strategy
+//
+// The Binary layout, encoded by the code cmd/bingen generates for UserInfo
+// (userinfo_bingen.go) instead of the hand-written encodeBinary. Values are
+// byte-identical to BinaryStrategy's; the generated code appends into one
+// right-sized buffer, sums fields without decoding whole records and patches
+// fixed-size fields in place on update.
+type GeneratedBinaryStrategy struct{}
+
+func (s *GeneratedBinaryStrategy) Name() string { return "Binary(gen)" }
+
+func (s *GeneratedBinaryStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_binary_gen"))
+		return err
+	})
+}
+
+func (s *GeneratedBinaryStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return appendUserInfoBinary(make([]byte, 0, userInfoBinarySize(user)), user), nil
+}
+
+func (s *GeneratedBinaryStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	user := &UserInfo{}
+	if err := decodeUserInfoBinary(data, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *GeneratedBinaryStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_binary_gen"))
+		data, _ := s.encodeValue(user)
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, data)
+	})
+}
+
+func (s *GeneratedBinaryStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_binary_gen"))
+		for _, user := range users {
+			data, _ := s.encodeValue(user)
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *GeneratedBinaryStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_binary_gen"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeValue(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *GeneratedBinaryStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_binary_gen"))
+		c := b.Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeValue(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *GeneratedBinaryStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_binary_gen"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+
+		// patchUserInfoField copies data, which belongs to bbolt.
+		newData, ok, err := patchUserInfoField(data, fieldName, value)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Strings change the record size: fall back to a full rewrite.
+			user, err := s.decodeValue(data)
+			if err != nil {
+				return err
+			}
+			if err := setUserInfoField(user, fieldName, value); err != nil {
+				return err
+			}
+			newData, _ = s.encodeValue(user)
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *GeneratedBinaryStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	// The generated reader covers every numeric field; only the summable
+	// ones are accepted, as in the other strategies.
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_binary_gen"))
+		c := b.Cursor()
+		processed := 0
+
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			n, err := readUserInfoNumber(v, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *GeneratedBinaryStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_binary_gen"), corrupt)
+}
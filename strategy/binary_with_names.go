@@ -112,133 +112,53 @@ func (s *BinaryWithNamesStrategy) encodeBinaryWithNames(user *UserInfo) ([]byte,
 	return buf.Bytes(), nil
 }
 
+// minNamedFieldSize is the smallest encoding of a field: the name length,
+// the tag and a one-byte bool.
+const minNamedFieldSize = 4 + 1 + 1
+
 // decodeBinaryWithNames deserializes []byte → *UserInfo, error on any mismatch.
 func (s *BinaryWithNamesStrategy) decodeBinaryWithNames(data []byte) (*UserInfo, error) {
-	buf := bytes.NewReader(data)
+	r := &binaryReader{data: data}
 	user := &UserInfo{}
 
-	var fieldCount int32
-	if err := binary.Read(buf, binary.LittleEndian, &fieldCount); err != nil {
-		return nil, fmt.Errorf("read field count: %w", err)
+	fieldCount := r.int32("field count")
+	if r.err == nil && (fieldCount < 0 || int64(fieldCount) > int64(len(r.data)/minNamedFieldSize)) {
+		r.fail(&LengthError{Field: "field count", Length: int64(fieldCount), Remaining: len(r.data)})
 	}
 
-	for i := int32(0); i < fieldCount; i++ {
-		// read field name
-		var nameLen int32
-		if err := binary.Read(buf, binary.LittleEndian, &nameLen); err != nil {
-			return nil, fmt.Errorf("read name length: %w", err)
-		}
-		nameBytes := make([]byte, nameLen)
-		if _, err := buf.Read(nameBytes); err != nil {
-			return nil, fmt.Errorf("read name: %w", err)
+	for i := int32(0); i < fieldCount && r.err == nil; i++ {
+		fieldName := string(r.bytes("field name"))
+		tag := r.next(fieldName+" tag", 1)[0]
+		if r.err != nil {
+			break
 		}
-		fieldName := string(nameBytes)
 
-		// read & assert type marker
-		tag, err := buf.ReadByte()
-		if err != nil {
-			return nil, fmt.Errorf("read tag %s: %w", fieldName, err)
+		// the tag must be the one the field is written with
+		fi, ok := lookupUserField(fieldName)
+		if !ok || userFields[fi].tag != tag {
+			r.fail(&TagError{Field: fieldName, Tag: tag})
+			break
 		}
+		f := &userFields[fi]
 
-		// now read payload based on tag
-		switch tag {
-		case tagInt64:
-			var v int64
-			if err := binary.Read(buf, binary.LittleEndian, &v); err != nil {
-				return nil, fmt.Errorf("read int64 %s: %w", fieldName, err)
-			}
-			switch fieldName {
-			case "id":
-				user.ID = v
-			case "created_at":
-				user.CreatedAt = v
-			case "updated_at":
-				user.UpdatedAt = v
-			default:
-				return nil, fmt.Errorf("unexpected int64 for %s", fieldName)
-			}
-
-		case tagString:
-			var strLen int32
-			if err := binary.Read(buf, binary.LittleEndian, &strLen); err != nil {
-				return nil, fmt.Errorf("read str len %s: %w", fieldName, err)
-			}
-			strBytes := make([]byte, strLen)
-			if _, err := buf.Read(strBytes); err != nil {
-				return nil, fmt.Errorf("read str %s: %w", fieldName, err)
-			}
-			switch fieldName {
-			case "username":
-				user.Username = string(strBytes)
-			case "email":
-				user.Email = string(strBytes)
-			case "first_name":
-				user.FirstName = string(strBytes)
-			case "last_name":
-				user.LastName = string(strBytes)
-			case "description":
-				user.Description = string(strBytes)
-			default:
-				return nil, fmt.Errorf("unexpected string for %s", fieldName)
-			}
-
-		case tagInt32:
-			var v int32
-			if err := binary.Read(buf, binary.LittleEndian, &v); err != nil {
-				return nil, fmt.Errorf("read int32 %s: %w", fieldName, err)
-			}
-			switch fieldName {
-			case "age":
-				user.Age = v
-			case "login_count":
-				user.LoginCount = v
-			default:
-				return nil, fmt.Errorf("unexpected int32 for %s", fieldName)
-			}
-
-		case tagFloat32:
-			var v float32
-			if err := binary.Read(buf, binary.LittleEndian, &v); err != nil {
-				return nil, fmt.Errorf("read float32 %s: %w", fieldName, err)
-			}
-			switch fieldName {
-			case "height":
-				user.Height = v
-			case "weight":
-				user.Weight = v
-			default:
-				return nil, fmt.Errorf("unexpected float32 for %s", fieldName)
-			}
-
-		case tagFloat64:
-			var v float64
-			if err := binary.Read(buf, binary.LittleEndian, &v); err != nil {
-				return nil, fmt.Errorf("read float64 %s: %w", fieldName, err)
-			}
-			switch fieldName {
-			case "balance":
-				user.Balance = v
-			case "score":
-				user.Score = v
-			default:
-				return nil, fmt.Errorf("unexpected float64 for %s", fieldName)
-			}
-
-		case tagBool:
-			var v bool
-			if err := binary.Read(buf, binary.LittleEndian, &v); err != nil {
-				return nil, fmt.Errorf("read bool %s: %w", fieldName, err)
-			}
-			if fieldName != "is_active" {
-				return nil, fmt.Errorf("unexpected bool for %s", fieldName)
-			}
-			user.IsActive = v
-
-		default:
-			return nil, fmt.Errorf("unknown tag %d for field %s", tag, fieldName)
+		var payload []byte
+		if tag == tagString {
+			payload = r.bytes(fieldName)
+		} else {
+			payload = r.next(fieldName, fixedSize(tag))
+		}
+		if r.err != nil {
+			break
+		}
+		if err := f.decodeValue(payload, user); err != nil {
+			r.fail(fmt.Errorf("%s: %w", fieldName, err))
 		}
 	}
+	r.end()
 
+	if r.err != nil {
+		return nil, fmt.Errorf("binary+names: %w", r.err)
+	}
 	return user, nil
 }
 
@@ -470,3 +390,15 @@ func (s *BinaryWithNamesStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, c
 	})
 	return sum, err
 }
+
+func (s *BinaryWithNamesStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeBinaryWithNames(user)
+}
+
+func (s *BinaryWithNamesStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeBinaryWithNames(data)
+}
+
+func (s *BinaryWithNamesStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_binary_names"), corrupt)
+}
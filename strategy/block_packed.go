@@ -0,0 +1,432 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"sort"
+)
+
+// 9. Block-packed strategy
+//
+// Up to BlockSize consecutive records share one value, stored under the ID
+// of the block's first record. A block is laid out as
+//
+//	count uint16 | count × (id uint64, end offset uint32) | records
+//
+// where each record uses the BinaryStrategy encoding and end offsets are
+// relative to the start of the records area.
+type BlockPackedStrategy struct {
+	// BlockSize is the maximum number of records per block. Defaults to 64.
+	BlockSize int
+
+	codec BinaryStrategy
+}
+
+const (
+	defaultBlockSize = 64
+	blockEntrySize   = 12
+)
+
+func (s *BlockPackedStrategy) Name() string { return fmt.Sprintf("Block%d", s.blockSize()) }
+
+func (s *BlockPackedStrategy) blockSize() int {
+	if s.BlockSize <= 0 {
+		return defaultBlockSize
+	}
+	return s.BlockSize
+}
+
+func (s *BlockPackedStrategy) Setup(db *bbolt.DB) error {
+	if s.blockSize() > 0xffff {
+		return fmt.Errorf("block size %d exceeds %d", s.blockSize(), 0xffff)
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_block"))
+		return err
+	})
+}
+
+// recordBlock is a decoded block; records hold the encoded records.
+type recordBlock struct {
+	ids     []int64
+	records [][]byte
+}
+
+func (blk *recordBlock) key() []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(blk.ids[0]))
+	return key
+}
+
+func (blk *recordBlock) last() int64 { return blk.ids[len(blk.ids)-1] }
+
+// find returns the position of id, or where it would be inserted.
+func (blk *recordBlock) find(id int64) (int, bool) {
+	i := sort.Search(len(blk.ids), func(i int) bool { return blk.ids[i] >= id })
+	return i, i < len(blk.ids) && blk.ids[i] == id
+}
+
+func (blk *recordBlock) insert(i int, id int64, record []byte) {
+	blk.ids = append(blk.ids, 0)
+	copy(blk.ids[i+1:], blk.ids[i:])
+	blk.ids[i] = id
+	blk.records = append(blk.records, nil)
+	copy(blk.records[i+1:], blk.records[i:])
+	blk.records[i] = record
+}
+
+func (blk *recordBlock) encode() []byte {
+	size := 2 + blockEntrySize*len(blk.ids)
+	for _, r := range blk.records {
+		size += len(r)
+	}
+	buf := make([]byte, 2+blockEntrySize*len(blk.ids), size)
+	binary.LittleEndian.PutUint16(buf, uint16(len(blk.ids)))
+	end := 0
+	for i, r := range blk.records {
+		end += len(r)
+		entry := buf[2+blockEntrySize*i:]
+		binary.LittleEndian.PutUint64(entry, uint64(blk.ids[i]))
+		binary.LittleEndian.PutUint32(entry[8:], uint32(end))
+		buf = append(buf, r...)
+	}
+	return buf
+}
+
+// blockEntry returns the id and encoded record at position i of an encoded
+// block, without decoding the rest of it.
+func blockEntry(data []byte, i int) (int64, []byte, error) {
+	count := int(binary.LittleEndian.Uint16(data))
+	records := data[2+blockEntrySize*count:]
+	entry := data[2+blockEntrySize*i:]
+	start := 0
+	if i > 0 {
+		start = int(binary.LittleEndian.Uint32(data[2+blockEntrySize*i-4:]))
+	}
+	end := int(binary.LittleEndian.Uint32(entry[8:]))
+	if start > end || end > len(records) {
+		return 0, nil, fmt.Errorf("block entry %d: bad offsets %d..%d", i, start, end)
+	}
+	return int64(binary.LittleEndian.Uint64(entry)), records[start:end], nil
+}
+
+// blockCount validates the header of an encoded block and returns its
+// number of records.
+func blockCount(data []byte) (int, error) {
+	if len(data) < 2 {
+		return 0, fmt.Errorf("block: truncated header")
+	}
+	count := int(binary.LittleEndian.Uint16(data))
+	if count == 0 || len(data) < 2+blockEntrySize*count {
+		return 0, fmt.Errorf("block: bad record count %d", count)
+	}
+	return count, nil
+}
+
+func decodeBlock(data []byte) (*recordBlock, error) {
+	count, err := blockCount(data)
+	if err != nil {
+		return nil, err
+	}
+	blk := &recordBlock{ids: make([]int64, count), records: make([][]byte, count)}
+	for i := range count {
+		if blk.ids[i], blk.records[i], err = blockEntry(data, i); err != nil {
+			return nil, err
+		}
+	}
+	return blk, nil
+}
+
+// searchBlock finds the position of id in an encoded block.
+func searchBlock(data []byte, id int64) (int, bool, error) {
+	count, err := blockCount(data)
+	if err != nil {
+		return 0, false, err
+	}
+	i := sort.Search(count, func(i int) bool {
+		return int64(binary.LittleEndian.Uint64(data[2+blockEntrySize*i:])) >= id
+	})
+	found := i < count && int64(binary.LittleEndian.Uint64(data[2+blockEntrySize*i:])) == id
+	return i, found, nil
+}
+
+// seekBlock positions c on the block that holds id, or would hold it: the
+// last block whose first ID is not greater than id.
+func (s *BlockPackedStrategy) seekBlock(c *bbolt.Cursor, id int64) ([]byte, []byte) {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	k, v := c.Seek(key)
+	if k != nil && bytes.Equal(k, key) {
+		return k, v
+	}
+	if k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+func (s *BlockPackedStrategy) putBlock(b *bbolt.Bucket, blk *recordBlock) error {
+	return b.Put(blk.key(), blk.encode())
+}
+
+// putRecord inserts or replaces a single record, splitting a block that
+// grows past BlockSize.
+func (s *BlockPackedStrategy) putRecord(b *bbolt.Bucket, user *UserInfo) error {
+	record := s.codec.encodeBinary(user)
+	c := b.Cursor()
+	k, v := s.seekBlock(c, user.ID)
+	if k == nil {
+		// id sorts before every block: prepend to the first one if it has
+		// room, otherwise start a new block.
+		k, v = c.First()
+		if k == nil {
+			return s.putBlock(b, &recordBlock{ids: []int64{user.ID}, records: [][]byte{record}})
+		}
+		blk, err := decodeBlock(v)
+		if err != nil {
+			return err
+		}
+		if len(blk.ids) >= s.blockSize() {
+			return s.putBlock(b, &recordBlock{ids: []int64{user.ID}, records: [][]byte{record}})
+		}
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+		blk.insert(0, user.ID, record)
+		return s.putBlock(b, blk)
+	}
+
+	blk, err := decodeBlock(v)
+	if err != nil {
+		return err
+	}
+	i, found := blk.find(user.ID)
+	if found {
+		blk.records[i] = record
+		return s.putBlock(b, blk)
+	}
+	blk.insert(i, user.ID, record)
+	if len(blk.ids) <= s.blockSize() {
+		return s.putBlock(b, blk)
+	}
+
+	// Appends spill into a fresh block; inserts in the middle split in half.
+	mid := len(blk.ids) / 2
+	if i == len(blk.ids)-1 {
+		mid = i
+	}
+	tail := &recordBlock{
+		ids:     append([]int64(nil), blk.ids[mid:]...),
+		records: append([][]byte(nil), blk.records[mid:]...),
+	}
+	blk.ids, blk.records = blk.ids[:mid], blk.records[:mid]
+	if err := s.putBlock(b, blk); err != nil {
+		return err
+	}
+	return s.putBlock(b, tail)
+}
+
+func (s *BlockPackedStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		return s.putRecord(tx.Bucket([]byte("users_block")), user)
+	})
+}
+
+func (s *BlockPackedStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	sorted := append([]*UserInfo(nil), users...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_block"))
+
+		// Runs of ascending IDs are appended to an in-memory block, which is
+		// stored once it is full or the run leaves its key range.
+		var cur *recordBlock
+		var limit []byte // first key of the block after cur
+		flush := func() error {
+			if cur == nil {
+				return nil
+			}
+			err := s.putBlock(b, cur)
+			cur = nil
+			return err
+		}
+		for _, user := range sorted {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if cur != nil && user.ID > cur.last() && len(cur.ids) < s.blockSize() &&
+				(limit == nil || bytes.Compare(key, limit) < 0) {
+				cur.insert(len(cur.ids), user.ID, s.codec.encodeBinary(user))
+				continue
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+
+			c := b.Cursor()
+			if k, v := s.seekBlock(c, user.ID); k != nil {
+				blk, err := decodeBlock(v)
+				if err != nil {
+					return err
+				}
+				if user.ID > blk.last() && len(blk.ids) < s.blockSize() {
+					limit, _ = c.Next()
+					cur = blk
+					cur.insert(len(cur.ids), user.ID, s.codec.encodeBinary(user))
+					continue
+				}
+			}
+			if err := s.putRecord(b, user); err != nil {
+				return err
+			}
+		}
+		return flush()
+	})
+}
+
+func (s *BlockPackedStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_block")).Cursor()
+		k, v := s.seekBlock(c, id)
+		if k == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		i, found, err := searchBlock(v, id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("user %d not found", id)
+		}
+		_, record, err := blockEntry(v, i)
+		if err != nil {
+			return err
+		}
+		user, err = s.codec.decodeBinary(record)
+		return err
+	})
+	return user, err
+}
+
+func (s *BlockPackedStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_block")).Cursor()
+		k, v := s.seekBlock(c, startId)
+		if k == nil {
+			k, v = c.First()
+		}
+		for ; k != nil && len(users) < count; k, v = c.Next() {
+			n, err := blockCount(v)
+			if err != nil {
+				return err
+			}
+			i, _, err := searchBlock(v, startId)
+			if err != nil {
+				return err
+			}
+			for ; i < n && len(users) < count; i++ {
+				_, record, err := blockEntry(v, i)
+				if err != nil {
+					return err
+				}
+				user, err := s.codec.decodeBinary(record)
+				if err != nil {
+					return err
+				}
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *BlockPackedStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_block"))
+		k, v := s.seekBlock(b.Cursor(), id)
+		if k == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		blk, err := decodeBlock(v)
+		if err != nil {
+			return err
+		}
+		i, found := blk.find(id)
+		if !found {
+			return fmt.Errorf("user %d not found", id)
+		}
+
+		user, err := s.codec.decodeBinary(blk.records[i])
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		blk.records[i] = s.codec.encodeBinary(user)
+		return s.putBlock(b, blk)
+	})
+}
+
+func (s *BlockPackedStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_block")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			n, err := blockCount(v)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n && processed < count; i++ {
+				_, record, err := blockEntry(v, i)
+				if err != nil {
+					return err
+				}
+				user, err := s.codec.decodeBinary(record)
+				if err != nil {
+					return err
+				}
+				f, err := userFieldNumber(user, fieldName)
+				if err != nil {
+					return err
+				}
+				sum += f
+				processed++
+			}
+		}
+		return nil
+	})
+	return sum, err
+}
+
+// Verify decodes every record of every block. A block whose header cannot
+// be read is reported under the ID of its first record.
+func (s *BlockPackedStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("users_block")).ForEach(func(k, v []byte) error {
+			first := int64(binary.BigEndian.Uint64(k))
+			count, err := blockCount(v)
+			if err != nil {
+				corrupt(first, err)
+				return nil
+			}
+			for i := range count {
+				id, record, err := blockEntry(v, i)
+				if err != nil {
+					corrupt(first, err)
+					return nil
+				}
+				if _, err := s.codec.decodeBinary(record); err != nil {
+					corrupt(id, err)
+				}
+			}
+			return nil
+		})
+	})
+}
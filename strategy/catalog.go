@@ -0,0 +1,82 @@
+package strategy
+
+// Catalog returns the strategies the benchmark runs, each configured as it
+// runs them. The encrypting ones use key.
+func Catalog(key *EncryptionKey) []StorageStrategy {
+	strategies := []StorageStrategy{
+		&JSONStrategy{},
+		&JSONArrayStrategy{},
+		&GOBStrategy{},
+		&GOBPreambleStrategy{},
+		&MsgPackStrategy{},
+		&MsgPackStrategy{ArrayMode: true},
+		&CBORStrategy{},
+		&CBORStrategy{IntKeys: true},
+		&ProtoWireStrategy{},
+		&AvroStrategy{},
+		&BinaryStrategy{},
+		&GeneratedBinaryStrategy{},
+		&CompactBinaryStrategy{},
+		&FixedOffsetStrategy{},
+		&FlatViewStrategy{},
+		&DeltaStrategy{},
+		&BinaryWithNamesStrategy{},
+		&MultiKVStrategy{},
+		&MultiKVIDStrategy{},
+		&NestedBucketStrategy{},
+		&HotColdStrategy{},
+		&ColumnarStrategy{},
+		&BlockPackedStrategy{BlockSize: 16},
+		&BlockPackedStrategy{BlockSize: 64},
+		&BlockPackedStrategy{BlockSize: 256},
+		&ReflectStrategy{Layout: LayoutBinary},
+		&ReflectStrategy{Layout: LayoutBinaryNames},
+		&ReflectStrategy{Layout: LayoutMultiKV},
+		&ReflectStrategy{Layout: LayoutNestedBucket},
+	}
+
+	// Compressed variants of the value-per-record strategies
+	for _, inner := range []StorageStrategy{
+		&JSONStrategy{},
+		&GOBStrategy{},
+		&BinaryStrategy{},
+		&BinaryWithNamesStrategy{},
+	} {
+		for _, codec := range []*Compression{
+			FlateCompression(1),
+			FlateCompression(6),
+			FlateCompression(9),
+			GzipCompression(),
+			LZWCompression(),
+		} {
+			strategies = append(strategies, &CompressedStrategy{Inner: inner, Codec: codec})
+		}
+		strategies = append(strategies, &DictCompressedStrategy{Inner: inner})
+	}
+
+	// Encrypted variants, whole values and only the PII fields
+	for _, inner := range []StorageStrategy{
+		&JSONStrategy{},
+		&BinaryStrategy{},
+	} {
+		strategies = append(strategies, &EncryptedStrategy{Inner: inner, Key: key})
+	}
+	for _, layout := range []Layout{LayoutMultiKV, LayoutNestedBucket} {
+		strategies = append(strategies, &FieldEncryptedStrategy{
+			Layout: layout,
+			Fields: []string{"email", "first_name", "last_name"},
+			Key:    key,
+		})
+	}
+
+	// Checksummed variants of the value-per-record strategies
+	for _, inner := range []StorageStrategy{
+		&JSONStrategy{},
+		&GOBStrategy{},
+		&BinaryStrategy{},
+		&BinaryWithNamesStrategy{},
+	} {
+		strategies = append(strategies, &ChecksummedStrategy{Inner: inner})
+	}
+	return strategies
+}
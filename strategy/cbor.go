@@ -0,0 +1,603 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+	"sort"
+)
+
+// 16. CBOR strategy
+//
+// Stores each record as a CBOR (RFC 8949) map, keyed by the json field
+// names or, with IntKeys, by the 1-based field number in userFields order.
+// The encoder follows the core deterministic encoding rules of section
+// 4.2.1: arguments in their shortest form, definite lengths only, map keys
+// sorted by their encoded bytes and floats in the shortest of half, single
+// or double precision that holds the value exactly. Equal records therefore
+// always encode to equal bytes. The decoder accepts either key form and
+// non-shortest arguments, map keys in any order and floats of any width,
+// but only definite lengths: indefinite-length strings, arrays and maps
+// (additional information 31) are rejected.
+type CBORStrategy struct {
+	IntKeys bool
+}
+
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+
+	cborFalse   = cborSimple | 20
+	cborTrue    = cborSimple | 21
+	cborNull    = cborSimple | 22
+	cborFloat16 = cborSimple | 25
+	cborFloat32 = cborSimple | 26
+	cborFloat64 = cborSimple | 27
+)
+
+// cborKeyOrder lists the userFields indexes in the order of their encoded
+// text keys: shorter keys first, then bytewise.
+var cborKeyOrder = func() []int {
+	order := make([]int, len(userFields))
+	keys := make([][]byte, len(userFields))
+	for i := range userFields {
+		order[i] = i
+		keys[i] = appendCBORText(nil, userFields[i].name)
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(keys[order[a]], keys[order[b]]) < 0
+	})
+	return order
+}()
+
+func (s *CBORStrategy) Name() string {
+	if s.IntKeys {
+		return "CBOR(intkeys)"
+	}
+	return "CBOR"
+}
+
+func (s *CBORStrategy) bucket() []byte {
+	if s.IntKeys {
+		return []byte("users_cbor_int")
+	}
+	return []byte("users_cbor")
+}
+
+func (s *CBORStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket())
+		return err
+	})
+}
+
+// appendCBORHead writes the initial byte of a data item with its argument
+// in the shortest form.
+func appendCBORHead(buf []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(buf, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(buf, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, major|27), arg)
+}
+
+func appendCBORInt(buf []byte, v int64) []byte {
+	if v < 0 {
+		return appendCBORHead(buf, cborNegInt, uint64(-1-v))
+	}
+	return appendCBORHead(buf, cborUint, uint64(v))
+}
+
+func appendCBORText(buf []byte, str string) []byte {
+	return append(appendCBORHead(buf, cborText, uint64(len(str))), str...)
+}
+
+// float16Bits returns the half-precision encoding of f, if f has one that
+// holds it exactly. NaNs map to the canonical quiet NaN.
+func float16Bits(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+	switch {
+	case exp == 0xff && mant != 0:
+		return 0x7e00, true
+	case exp == 0xff:
+		return sign | 0x7c00, true
+	case exp == 0 && mant == 0:
+		return sign, true
+	case exp == 0:
+		return 0, false // float32 subnormals are below the half range
+	}
+	e := exp - 127
+	switch {
+	case e >= -14 && e <= 15:
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(e+15)<<10 | uint16(mant>>13), true
+	case e >= -24 && e < -14:
+		full := 0x800000 | mant
+		shift := uint(-(e + 1))
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	}
+	return 0, false
+}
+
+func float16Value(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * math.Ldexp(1024+mant, exp-25)
+}
+
+// appendCBORFloat writes v in the shortest precision that is lossless.
+func appendCBORFloat(buf []byte, v float64) []byte {
+	if f := float32(v); float64(f) == v || math.IsNaN(v) {
+		if h, ok := float16Bits(f); ok {
+			return binary.BigEndian.AppendUint16(append(buf, cborFloat16), h)
+		}
+		return binary.BigEndian.AppendUint32(append(buf, cborFloat32), math.Float32bits(f))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, cborFloat64), math.Float64bits(v))
+}
+
+// appendCBORField writes the value of userFields[i].
+func appendCBORField(buf []byte, i int, user *UserInfo) []byte {
+	switch userFields[i].name {
+	case "id":
+		return appendCBORInt(buf, user.ID)
+	case "username":
+		return appendCBORText(buf, user.Username)
+	case "email":
+		return appendCBORText(buf, user.Email)
+	case "first_name":
+		return appendCBORText(buf, user.FirstName)
+	case "last_name":
+		return appendCBORText(buf, user.LastName)
+	case "age":
+		return appendCBORInt(buf, int64(user.Age))
+	case "height":
+		return appendCBORFloat(buf, float64(user.Height))
+	case "weight":
+		return appendCBORFloat(buf, float64(user.Weight))
+	case "balance":
+		return appendCBORFloat(buf, user.Balance)
+	case "is_active":
+		if user.IsActive {
+			return append(buf, cborTrue)
+		}
+		return append(buf, cborFalse)
+	case "created_at":
+		return appendCBORInt(buf, user.CreatedAt)
+	case "updated_at":
+		return appendCBORInt(buf, user.UpdatedAt)
+	case "login_count":
+		return appendCBORInt(buf, int64(user.LoginCount))
+	case "score":
+		return appendCBORFloat(buf, user.Score)
+	case "description":
+		return appendCBORText(buf, user.Description)
+	}
+	panic("cbor: unhandled field " + userFields[i].name)
+}
+
+func (s *CBORStrategy) encodeCBOR(user *UserInfo) []byte {
+	buf := make([]byte, 0, 192+len(user.Username)+len(user.Email)+
+		len(user.FirstName)+len(user.LastName)+len(user.Description))
+	buf = appendCBORHead(buf, cborMap, uint64(len(userFields)))
+	if s.IntKeys {
+		// Small unsigned keys sort numerically.
+		for i := range userFields {
+			buf = appendCBORHead(buf, cborUint, uint64(i+1))
+			buf = appendCBORField(buf, i, user)
+		}
+		return buf
+	}
+	for _, i := range cborKeyOrder {
+		buf = appendCBORText(buf, userFields[i].name)
+		buf = appendCBORField(buf, i, user)
+	}
+	return buf
+}
+
+// cborMaxDepth bounds the nesting of items the decoder will skip.
+const cborMaxDepth = 32
+
+// cborReader consumes CBOR data items, remembering the first error.
+type cborReader struct {
+	data []byte
+	err  error
+}
+
+func (r *cborReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("cbor: "+format, args...)
+	}
+	r.data = nil
+}
+
+func (r *cborReader) next(n uint64) []byte {
+	if n > uint64(len(r.data)) {
+		r.fail("truncated")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// head reads the initial byte of an item and its argument. For floats the
+// argument is the raw bits.
+func (r *cborReader) head() (major, info byte, arg uint64) {
+	b := r.next(1)
+	if b == nil {
+		return 0, 0, 0
+	}
+	major, info = b[0]&0xe0, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info)
+	case info == 24:
+		if b := r.next(1); b != nil {
+			return major, info, uint64(b[0])
+		}
+	case info == 25:
+		if b := r.next(2); b != nil {
+			return major, info, uint64(binary.BigEndian.Uint16(b))
+		}
+	case info == 26:
+		if b := r.next(4); b != nil {
+			return major, info, uint64(binary.BigEndian.Uint32(b))
+		}
+	case info == 27:
+		if b := r.next(8); b != nil {
+			return major, info, binary.BigEndian.Uint64(b)
+		}
+	default:
+		r.fail("unsupported additional information %d", info)
+	}
+	return 0, 0, 0
+}
+
+func (r *cborReader) mapLen() uint64 {
+	major, _, n := r.head()
+	if r.err == nil && major != cborMap {
+		r.fail("expected map, got major type %d", major>>5)
+		return 0
+	}
+	return n
+}
+
+func (r *cborReader) text() []byte {
+	major, info, n := r.head()
+	switch {
+	case r.err != nil:
+		return nil
+	case major == cborText, major == cborBytes:
+		return r.next(n)
+	case major == cborSimple && info == 22:
+		return nil
+	}
+	r.fail("expected string, got major type %d", major>>5)
+	return nil
+}
+
+func (r *cborReader) int() int64 {
+	major, info, n := r.head()
+	switch {
+	case r.err != nil:
+	case major == cborUint && n <= math.MaxInt64:
+		return int64(n)
+	case major == cborNegInt && n <= math.MaxInt64:
+		return -1 - int64(n)
+	case major == cborUint, major == cborNegInt:
+		r.fail("integer overflows int64")
+	case major == cborSimple && info == 22:
+		return 0
+	default:
+		r.fail("expected integer, got major type %d", major>>5)
+	}
+	return 0
+}
+
+func (r *cborReader) int32(what string) int32 {
+	v := r.int()
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		r.fail("%s: %d overflows int32", what, v)
+		return 0
+	}
+	return int32(v)
+}
+
+// float reads a float of any precision, or an integer.
+func (r *cborReader) float() float64 {
+	if len(r.data) == 0 {
+		r.fail("truncated")
+		return 0
+	}
+	if r.data[0]&0xe0 != cborSimple {
+		return float64(r.int())
+	}
+	_, info, bits := r.head()
+	switch {
+	case r.err != nil:
+	case info == 25:
+		return float16Value(uint16(bits))
+	case info == 26:
+		return float64(math.Float32frombits(uint32(bits)))
+	case info == 27:
+		return math.Float64frombits(bits)
+	case info == 22:
+		return 0
+	default:
+		r.fail("expected float, got simple value %d", info)
+	}
+	return 0
+}
+
+func (r *cborReader) bool() bool {
+	major, info, _ := r.head()
+	switch {
+	case r.err != nil:
+	case major == cborSimple && info == 21:
+		return true
+	case major == cborSimple && (info == 20 || info == 22):
+		return false
+	default:
+		r.fail("expected bool, got major type %d", major>>5)
+	}
+	return false
+}
+
+// skip consumes one data item of any type.
+func (r *cborReader) skip(depth int) {
+	if depth > cborMaxDepth {
+		r.fail("nesting deeper than %d", cborMaxDepth)
+		return
+	}
+	major, _, n := r.head()
+	switch {
+	case r.err != nil:
+	case major == cborBytes, major == cborText:
+		r.next(n)
+	case major == cborArray:
+		for ; n > 0 && r.err == nil; n-- {
+			r.skip(depth + 1)
+		}
+	case major == cborMap:
+		for ; n > 0 && r.err == nil; n-- {
+			r.skip(depth + 1)
+			r.skip(depth + 1)
+		}
+	case major == cborTag:
+		r.skip(depth + 1)
+	}
+}
+
+// key reads a map key and returns the userFields index it names, or -1.
+func (r *cborReader) key() int {
+	if len(r.data) > 0 && r.data[0]&0xe0 == cborUint {
+		n := r.int()
+		if n >= 1 && n <= int64(len(userFields)) {
+			return int(n - 1)
+		}
+		return -1
+	}
+	i, ok := lookupUserField(string(r.text()))
+	if !ok {
+		return -1
+	}
+	return i
+}
+
+// decodeField reads the value of userFields[i] into user.
+func (r *cborReader) decodeField(i int, user *UserInfo) {
+	switch userFields[i].name {
+	case "id":
+		user.ID = r.int()
+	case "username":
+		user.Username = string(r.text())
+	case "email":
+		user.Email = string(r.text())
+	case "first_name":
+		user.FirstName = string(r.text())
+	case "last_name":
+		user.LastName = string(r.text())
+	case "age":
+		user.Age = r.int32("age")
+	case "height":
+		user.Height = float32(r.float())
+	case "weight":
+		user.Weight = float32(r.float())
+	case "balance":
+		user.Balance = r.float()
+	case "is_active":
+		user.IsActive = r.bool()
+	case "created_at":
+		user.CreatedAt = r.int()
+	case "updated_at":
+		user.UpdatedAt = r.int()
+	case "login_count":
+		user.LoginCount = r.int32("login_count")
+	case "score":
+		user.Score = r.float()
+	case "description":
+		user.Description = string(r.text())
+	}
+}
+
+func (s *CBORStrategy) decodeCBOR(data []byte) (*UserInfo, error) {
+	r := &cborReader{data: data}
+	user := &UserInfo{}
+	for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+		if i := r.key(); i >= 0 {
+			r.decodeField(i, user)
+		} else {
+			r.skip(0)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("cbor: %d trailing bytes", len(r.data))
+	}
+	return user, nil
+}
+
+// fieldNumber reads the numeric field at userFields[idx] of a record,
+// skipping the other values without decoding them.
+func (s *CBORStrategy) fieldNumber(data []byte, idx int) (float64, error) {
+	r := &cborReader{data: data}
+	for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+		if r.key() == idx {
+			return r.float(), r.err
+		}
+		r.skip(0)
+	}
+	return 0, r.err
+}
+
+func (s *CBORStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, s.encodeCBOR(user))
+	})
+}
+
+func (s *CBORStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		for _, user := range users {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, s.encodeCBOR(user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *CBORStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeCBOR(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *CBORStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket()).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeCBOR(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *CBORStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err := s.decodeCBOR(data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		return b.Put(key, s.encodeCBOR(user))
+	})
+}
+
+func (s *CBORStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	idx, _ := lookupUserField(fieldName)
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket()).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			n, err := s.fieldNumber(v, idx)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *CBORStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeCBOR(user), nil
+}
+
+func (s *CBORStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeCBOR(data)
+}
+
+func (s *CBORStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, s.bucket(), corrupt)
+}
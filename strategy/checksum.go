@@ -0,0 +1,226 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"hash/crc32"
+)
+
+// 27. Integrity envelope
+//
+// Wraps a strategy that stores one value per record and puts each value in
+// an envelope:
+//
+//	version byte | CRC32C (Castagnoli) of the payload, 4 bytes little-endian | payload
+//
+// Read, ReadMany and ReadFieldSum check the checksum before decoding, so a
+// value corrupted on disk is reported as a *ChecksumError instead of being
+// decoded into a wrong record.
+type ChecksummedStrategy struct {
+	Inner StorageStrategy
+}
+
+// checksumVersion is the envelope version this code writes.
+const checksumVersion = 1
+
+const checksumHeaderSize = 1 + 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError reports a value whose payload does not match its stored
+// CRC32C.
+type ChecksumError struct {
+	ID       int64
+	Stored   uint32
+	Computed uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("user %d: crc32c mismatch: stored %08x, computed %08x", e.ID, e.Stored, e.Computed)
+}
+
+func (s *ChecksummedStrategy) Name() string {
+	return s.Inner.Name() + "+crc32c"
+}
+
+// Unwrap returns the strategy whose values are checksummed.
+func (s *ChecksummedStrategy) Unwrap() StorageStrategy { return s.Inner }
+
+func (s *ChecksummedStrategy) Setup(db *bbolt.DB) error {
+	if _, err := asValueCodec(s.Inner); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_checksummed"))
+		return err
+	})
+}
+
+func (s *ChecksummedStrategy) encode(inner valueCodec, user *UserInfo) ([]byte, error) {
+	payload, err := inner.encodeValue(user)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, checksumHeaderSize, checksumHeaderSize+len(payload))
+	buf[0] = checksumVersion
+	binary.LittleEndian.PutUint32(buf[1:], crc32.Checksum(payload, castagnoli))
+	return append(buf, payload...), nil
+}
+
+// open checks the envelope of the value stored under key and returns its
+// payload.
+func (s *ChecksummedStrategy) open(key, data []byte) ([]byte, error) {
+	id := int64(binary.BigEndian.Uint64(key))
+	if len(data) < checksumHeaderSize {
+		return nil, fmt.Errorf("user %d: envelope: %w", id, ErrTruncated)
+	}
+	if data[0] != checksumVersion {
+		return nil, fmt.Errorf("user %d: unknown envelope version %d", id, data[0])
+	}
+	payload := data[checksumHeaderSize:]
+	stored := binary.LittleEndian.Uint32(data[1:])
+	if computed := crc32.Checksum(payload, castagnoli); computed != stored {
+		return nil, &ChecksumError{ID: id, Stored: stored, Computed: computed}
+	}
+	return payload, nil
+}
+
+func (s *ChecksummedStrategy) decode(inner valueCodec, key, data []byte) (*UserInfo, error) {
+	payload, err := s.open(key, data)
+	if err != nil {
+		return nil, err
+	}
+	return inner.decodeValue(payload)
+}
+
+func (s *ChecksummedStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_checksummed"))
+		data, err := s.encode(inner, user)
+		if err != nil {
+			return err
+		}
+		return b.Put(idKey(user.ID), data)
+	})
+}
+
+func (s *ChecksummedStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_checksummed"))
+		for _, user := range users {
+			data, err := s.encode(inner, user)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(idKey(user.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ChecksummedStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return nil, err
+	}
+	var user *UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		key := idKey(id)
+		data := tx.Bucket([]byte("users_checksummed")).Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decode(inner, key, data)
+		return err
+	})
+	return user, err
+}
+
+func (s *ChecksummedStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return nil, err
+	}
+	var users []*UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_checksummed")).Cursor()
+		for k, v := c.Seek(idKey(startId)); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decode(inner, k, v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *ChecksummedStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_checksummed"))
+		key := idKey(id)
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err := s.decode(inner, key, data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		newData, err := s.encode(inner, user)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *ChecksummedStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_checksummed")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			user, err := s.decode(inner, k, v)
+			if err != nil {
+				return err
+			}
+			n, _ := userFieldNumber(user, fieldName)
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *ChecksummedStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_checksummed"), corrupt)
+}
@@ -0,0 +1,65 @@
+package strategy_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	. "boltdb_benchmarks/strategy"
+	"boltdb_benchmarks/strategy/conformance"
+	"go.etcd.io/bbolt"
+)
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "crc.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &ChecksummedStrategy{Inner: &BinaryStrategy{}}
+	if err := s.Setup(db); err != nil {
+		t.Fatal(err)
+	}
+	users := conformance.Records()
+	if err := s.WriteMany(db, users); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip one bit of updated_at, which the Binary decoder alone would
+	// accept.
+	victim := users[7].ID
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_checksummed"))
+		key := binary.BigEndian.AppendUint64(nil, uint64(victim))
+		data := append([]byte(nil), b.Get(key)...)
+		data[len(data)-20] ^= 0x01
+		return b.Put(key, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var crcErr *ChecksumError
+	if _, err := s.Read(db, victim); !errors.As(err, &crcErr) || crcErr.ID != victim {
+		t.Errorf("Read(%d) = %v, want a ChecksumError", victim, err)
+	}
+	if _, err := s.Read(db, users[6].ID); err != nil {
+		t.Errorf("Read(%d) of an intact record: %v", users[6].ID, err)
+	}
+	if _, err := s.ReadMany(db, users[0].ID, len(users)); !errors.As(err, &crcErr) {
+		t.Errorf("ReadMany = %v, want a ChecksumError", err)
+	}
+	if _, err := s.ReadFieldSum(db, "balance", len(users)); !errors.As(err, &crcErr) {
+		t.Errorf("ReadFieldSum = %v, want a ChecksumError", err)
+	}
+
+	var reported []int64
+	err = Verify(db, s, func(id int64, err error) { reported = append(reported, id) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || reported[0] != victim {
+		t.Errorf("Verify reported %v, want [%d]", reported, victim)
+	}
+}
@@ -0,0 +1,202 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// 8. Columnar strategy
+//
+// Each field gets its own bucket (users_col_balance, users_col_email, ...)
+// keyed by ID, so summing a field scans one dense bucket.
+type ColumnarStrategy struct{}
+
+func (s *ColumnarStrategy) Name() string { return "Columnar" }
+
+func columnBucket(field string) []byte {
+	return []byte("users_col_" + field)
+}
+
+func (s *ColumnarStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		for _, f := range userFields {
+			if _, err := tx.CreateBucketIfNotExists(columnBucket(f.name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ColumnarStrategy) columns(tx *bbolt.Tx) ([]*bbolt.Bucket, error) {
+	cols := make([]*bbolt.Bucket, len(userFields))
+	for i, f := range userFields {
+		if cols[i] = tx.Bucket(columnBucket(f.name)); cols[i] == nil {
+			return nil, fmt.Errorf("bucket users_col_%s not found", f.name)
+		}
+	}
+	return cols, nil
+}
+
+func (s *ColumnarStrategy) writeUserFields(cols []*bbolt.Bucket, user *UserInfo) error {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(user.ID))
+	for i := range userFields {
+		if err := cols[i].Put(key, userFields[i].appendValue(nil, user)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ColumnarStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		cols, err := s.columns(tx)
+		if err != nil {
+			return err
+		}
+		return s.writeUserFields(cols, user)
+	})
+}
+
+func (s *ColumnarStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		cols, err := s.columns(tx)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := s.writeUserFields(cols, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ColumnarStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	user := &UserInfo{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		cols, err := s.columns(tx)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		for i, f := range userFields {
+			data := cols[i].Get(key)
+			if data == nil {
+				return fmt.Errorf("user %d not found", id)
+			}
+			if err := f.decodeValue(data, user); err != nil {
+				return fmt.Errorf("user %d %s: %w", id, f.name, err)
+			}
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (s *ColumnarStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		cols, err := s.columns(tx)
+		if err != nil {
+			return err
+		}
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		// The ID column drives the scan; the other columns are walked in
+		// lockstep since every column holds the same keys.
+		cursors := make([]*bbolt.Cursor, len(cols))
+		keys := make([][]byte, len(cols))
+		values := make([][]byte, len(cols))
+		for i, b := range cols {
+			cursors[i] = b.Cursor()
+			keys[i], values[i] = cursors[i].Seek(startKey)
+		}
+
+		for keys[0] != nil && len(users) < count {
+			k := keys[0]
+			user := &UserInfo{}
+			for i, f := range userFields {
+				v := values[i]
+				if !bytes.Equal(keys[i], k) {
+					if v = cols[i].Get(k); v == nil {
+						return fmt.Errorf("user %d: missing %s", binary.BigEndian.Uint64(k), f.name)
+					}
+				}
+				if err := f.decodeValue(v, user); err != nil {
+					return fmt.Errorf("user %d %s: %w", binary.BigEndian.Uint64(k), f.name, err)
+				}
+			}
+			users = append(users, user)
+			for i := range cursors {
+				keys[i], values[i] = cursors[i].Next()
+			}
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *ColumnarStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	i, ok := lookupUserField(fieldName)
+	if !ok {
+		return fmt.Errorf("field %q is not updatable", fieldName)
+	}
+	var user UserInfo
+	if err := setUserField(&user, fieldName, value); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(columnBucket(fieldName))
+		if b == nil {
+			return fmt.Errorf("bucket users_col_%s not found", fieldName)
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		if b.Get(key) == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		return b.Put(key, userFields[i].appendValue(nil, &user))
+	})
+}
+
+func (s *ColumnarStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	i, ok := lookupUserField(fieldName)
+	if !ok {
+		return 0, fmt.Errorf("cannot sum field %q", fieldName)
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(columnBucket(fieldName))
+		if b == nil {
+			return fmt.Errorf("bucket users_col_%s not found", fieldName)
+		}
+		c := b.Cursor()
+		processed := 0
+		var user UserInfo
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			if err := userFields[i].decodeValue(v, &user); err != nil {
+				return err
+			}
+			n, err := userFieldNumber(&user, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *ColumnarStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, columnBucket("id"), corrupt)
+}
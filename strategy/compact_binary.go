@@ -0,0 +1,261 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+)
+
+// 10. Compact binary strategy
+//
+// Like BinaryStrategy, but integers are zigzag varints, lengths are uvarints,
+// timestamps are stored as a delta against compactEpoch and booleans are
+// packed into a flags byte.
+type CompactBinaryStrategy struct{}
+
+// compactEpoch is the base for timestamp deltas (2023-11-14T22:13:20Z).
+const compactEpoch = 1_700_000_000
+
+const compactFlagActive = 1 << 0
+
+func (s *CompactBinaryStrategy) Name() string { return "CompactBinary" }
+
+func (s *CompactBinaryStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_compact"))
+		return err
+	})
+}
+
+func appendCompactString(buf []byte, str string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(str)))
+	return append(buf, str...)
+}
+
+func (s *CompactBinaryStrategy) encodeCompact(user *UserInfo) []byte {
+	buf := make([]byte, 0, 64+len(user.Username)+len(user.Email)+
+		len(user.FirstName)+len(user.LastName)+len(user.Description))
+
+	var flags byte
+	if user.IsActive {
+		flags |= compactFlagActive
+	}
+	buf = append(buf, flags)
+	buf = binary.AppendVarint(buf, user.ID)
+
+	buf = appendCompactString(buf, user.Username)
+	buf = appendCompactString(buf, user.Email)
+	buf = appendCompactString(buf, user.FirstName)
+	buf = appendCompactString(buf, user.LastName)
+	buf = appendCompactString(buf, user.Description)
+
+	buf = binary.AppendVarint(buf, int64(user.Age))
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(user.Height))
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(user.Weight))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(user.Balance))
+	buf = binary.AppendVarint(buf, user.CreatedAt-compactEpoch)
+	buf = binary.AppendVarint(buf, user.UpdatedAt-compactEpoch)
+	buf = binary.AppendVarint(buf, int64(user.LoginCount))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(user.Score))
+	return buf
+}
+
+// compactReader consumes a compact record, remembering the first error.
+type compactReader struct {
+	data []byte
+	err  error
+}
+
+func (r *compactReader) fail(what string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("compact: truncated or malformed %s", what)
+	}
+	r.data = nil
+}
+
+func (r *compactReader) varint(what string) int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail(what)
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *compactReader) varint32(what string) int32 {
+	v := r.varint(what)
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		r.fail(what)
+		return 0
+	}
+	return int32(v)
+}
+
+func (r *compactReader) string(what string) string {
+	n, size := binary.Uvarint(r.data)
+	if size <= 0 || n > uint64(len(r.data)-size) {
+		r.fail(what)
+		return ""
+	}
+	str := string(r.data[size : size+int(n)])
+	r.data = r.data[size+int(n):]
+	return str
+}
+
+func (r *compactReader) fixed(what string, n int) []byte {
+	if len(r.data) < n {
+		r.fail(what)
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (s *CompactBinaryStrategy) decodeCompact(data []byte) (*UserInfo, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("compact: empty record")
+	}
+	r := &compactReader{data: data[1:]}
+	user := &UserInfo{IsActive: data[0]&compactFlagActive != 0}
+
+	user.ID = r.varint("id")
+	user.Username = r.string("username")
+	user.Email = r.string("email")
+	user.FirstName = r.string("first_name")
+	user.LastName = r.string("last_name")
+	user.Description = r.string("description")
+
+	user.Age = r.varint32("age")
+	user.Height = math.Float32frombits(binary.LittleEndian.Uint32(r.fixed("height", 4)))
+	user.Weight = math.Float32frombits(binary.LittleEndian.Uint32(r.fixed("weight", 4)))
+	user.Balance = math.Float64frombits(binary.LittleEndian.Uint64(r.fixed("balance", 8)))
+	user.CreatedAt = r.varint("created_at") + compactEpoch
+	user.UpdatedAt = r.varint("updated_at") + compactEpoch
+	user.LoginCount = r.varint32("login_count")
+	user.Score = math.Float64frombits(binary.LittleEndian.Uint64(r.fixed("score", 8)))
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("compact: %d trailing bytes", len(r.data))
+	}
+	return user, nil
+}
+
+func (s *CompactBinaryStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compact"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, s.encodeCompact(user))
+	})
+}
+
+func (s *CompactBinaryStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compact"))
+		for _, user := range users {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, s.encodeCompact(user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *CompactBinaryStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compact"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeCompact(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *CompactBinaryStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_compact")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeCompact(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *CompactBinaryStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compact"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err := s.decodeCompact(data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		return b.Put(key, s.encodeCompact(user))
+	})
+}
+
+func (s *CompactBinaryStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_compact")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			user, err := s.decodeCompact(v)
+			if err != nil {
+				return err
+			}
+			n, err := userFieldNumber(user, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *CompactBinaryStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeCompact(user), nil
+}
+
+func (s *CompactBinaryStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeCompact(data)
+}
+
+func (s *CompactBinaryStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_compact"), corrupt)
+}
@@ -0,0 +1,322 @@
+package strategy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"sync"
+)
+
+// 11. Compression decorator
+//
+// Wraps a strategy that stores one value per record (JSON, GOB, Binary,
+// Binary+Names, ...) and compresses each value before it is stored.
+type CompressedStrategy struct {
+	Inner StorageStrategy
+	Codec *Compression
+}
+
+// Compression is a stdlib compression codec. Writers and readers are pooled,
+// since allocating a flate writer per record would dominate the benchmark.
+type Compression struct {
+	name       string
+	compress   func(dst io.Writer, data []byte) error
+	decompress func(src io.Reader, dst *bytes.Buffer) error
+}
+
+func (c *Compression) Name() string { return c.name }
+
+func (c *Compression) encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.compress(&buf, data); err != nil {
+		return nil, fmt.Errorf("%s compress: %w", c.name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Compression) decode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.decompress(bytes.NewReader(data), &buf); err != nil {
+		return nil, fmt.Errorf("%s decompress: %w", c.name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// FlateCompression returns a compress/flate codec at the given level.
+func FlateCompression(level int) *Compression {
+	var writers, readers sync.Pool
+	return &Compression{
+		name: fmt.Sprintf("flate%d", level),
+		compress: func(dst io.Writer, data []byte) error {
+			w, _ := writers.Get().(*flate.Writer)
+			if w == nil {
+				var err error
+				if w, err = flate.NewWriter(dst, level); err != nil {
+					return err
+				}
+			} else {
+				w.Reset(dst)
+			}
+			defer writers.Put(w)
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			return w.Close()
+		},
+		decompress: func(src io.Reader, dst *bytes.Buffer) error {
+			r, _ := readers.Get().(io.ReadCloser)
+			if r == nil {
+				r = flate.NewReader(src)
+			} else if err := r.(flate.Resetter).Reset(src, nil); err != nil {
+				return err
+			}
+			defer readers.Put(r)
+			_, err := dst.ReadFrom(r)
+			return err
+		},
+	}
+}
+
+// GzipCompression returns a compress/gzip codec at the default level.
+func GzipCompression() *Compression {
+	var writers, readers sync.Pool
+	return &Compression{
+		name: "gzip",
+		compress: func(dst io.Writer, data []byte) error {
+			w, _ := writers.Get().(*gzip.Writer)
+			if w == nil {
+				w = gzip.NewWriter(dst)
+			} else {
+				w.Reset(dst)
+			}
+			defer writers.Put(w)
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			return w.Close()
+		},
+		decompress: func(src io.Reader, dst *bytes.Buffer) error {
+			r, _ := readers.Get().(*gzip.Reader)
+			if r == nil {
+				var err error
+				if r, err = gzip.NewReader(src); err != nil {
+					return err
+				}
+			} else if err := r.Reset(src); err != nil {
+				return err
+			}
+			defer readers.Put(r)
+			_, err := dst.ReadFrom(r)
+			return err
+		},
+	}
+}
+
+// LZWCompression returns a compress/lzw codec (LSB order, 8-bit literals).
+func LZWCompression() *Compression {
+	var writers, readers sync.Pool
+	return &Compression{
+		name: "lzw",
+		compress: func(dst io.Writer, data []byte) error {
+			w, _ := writers.Get().(*lzw.Writer)
+			if w == nil {
+				w = lzw.NewWriter(dst, lzw.LSB, 8).(*lzw.Writer)
+			} else {
+				w.Reset(dst, lzw.LSB, 8)
+			}
+			defer writers.Put(w)
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			return w.Close()
+		},
+		decompress: func(src io.Reader, dst *bytes.Buffer) error {
+			r, _ := readers.Get().(*lzw.Reader)
+			if r == nil {
+				r = lzw.NewReader(src, lzw.LSB, 8).(*lzw.Reader)
+			} else {
+				r.Reset(src, lzw.LSB, 8)
+			}
+			defer readers.Put(r)
+			_, err := dst.ReadFrom(r)
+			return err
+		},
+	}
+}
+
+func (s *CompressedStrategy) Name() string {
+	return s.Inner.Name() + "+" + s.Codec.Name()
+}
+
+func (s *CompressedStrategy) Setup(db *bbolt.DB) error {
+	if _, err := asValueCodec(s.Inner); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_compressed"))
+		return err
+	})
+}
+
+func (s *CompressedStrategy) encode(inner valueCodec, user *UserInfo) ([]byte, error) {
+	data, err := inner.encodeValue(user)
+	if err != nil {
+		return nil, err
+	}
+	return s.Codec.encode(data)
+}
+
+func (s *CompressedStrategy) decode(inner valueCodec, data []byte) (*UserInfo, error) {
+	raw, err := s.Codec.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return inner.decodeValue(raw)
+}
+
+func (s *CompressedStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compressed"))
+		data, err := s.encode(inner, user)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, data)
+	})
+}
+
+func (s *CompressedStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compressed"))
+		for _, user := range users {
+			data, err := s.encode(inner, user)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *CompressedStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return nil, err
+	}
+	var user *UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compressed"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decode(inner, data)
+		return err
+	})
+	return user, err
+}
+
+func (s *CompressedStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return nil, err
+	}
+	var users []*UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_compressed")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decode(inner, v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *CompressedStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_compressed"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err := s.decode(inner, data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		newData, err := s.encode(inner, user)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *CompressedStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_compressed")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			user, err := s.decode(inner, v)
+			if err != nil {
+				return err
+			}
+			n, err := userFieldNumber(user, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *CompressedStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_compressed"), corrupt)
+}
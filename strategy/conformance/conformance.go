@@ -0,0 +1,283 @@
+// Package conformance checks that a StorageStrategy returns what was stored.
+//
+// Run writes a fixed set of records to a fresh database and compares every
+// Read, ReadMany, UpdateField and ReadFieldSum against an in-memory copy of
+// the same records, and checks that Verify finds nothing wrong with them:
+//
+//	func TestJSON(t *testing.T) {
+//		conformance.Run(t, func() strategy.StorageStrategy { return &strategy.JSONStrategy{} }, conformance.Options{})
+//	}
+package conformance
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"boltdb_benchmarks/strategy"
+	"go.etcd.io/bbolt"
+)
+
+// Options adjusts the checks for one strategy.
+type Options struct {
+	// Lossy lists fields the strategy is known not to store exactly. They
+	// are left out of record comparisons, updates and sums.
+	Lossy []string
+}
+
+// numericFields are the fields UpdateField and ReadFieldSum support, with
+// the value each one is updated to.
+var numericFields = []struct {
+	name  string
+	value interface{}
+}{
+	{"balance", 12345.67},
+	{"score", -0.125},
+	{"login_count", int32(-7)},
+}
+
+// Records returns the records Run stores. IDs are ascending but not
+// contiguous, and the fields cover zero values, negative numbers,
+// fractional floats and multi-byte strings.
+func Records() []*strategy.UserInfo {
+	users := []*strategy.UserInfo{{ID: 0}}
+	for i := int64(1); i <= 40; i++ {
+		id := i*3 + i%2
+		users = append(users, &strategy.UserInfo{
+			ID:          id,
+			Username:    fmt.Sprintf("user_%d", id),
+			Email:       fmt.Sprintf("user%d@example.com", id),
+			FirstName:   fmt.Sprintf("First_%d", id),
+			LastName:    fmt.Sprintf("Lást_%d ✓", id),
+			Age:         int32(18 + i),
+			Height:      150.5 + float32(i)/8,
+			Weight:      50.25 + float32(i)/3,
+			Balance:     float64(i)*1234.5678 - 9000,
+			IsActive:    i%3 == 0,
+			CreatedAt:   1_700_000_000 - i*86_400,
+			UpdatedAt:   1_700_000_000 + i,
+			LoginCount:  int32(i*37) - 500,
+			Score:       float64(i) / 7,
+			Description: fmt.Sprintf("Description of user %d, with a comma, \"quotes\" and a\nnewline.", id),
+		})
+	}
+	return users
+}
+
+// Run runs the conformance checks against the strategies newStrategy
+// returns, each on a fresh database, writing records one at a time and in
+// bulk.
+func Run(t *testing.T, newStrategy func() strategy.StorageStrategy, opts Options) {
+	t.Helper()
+	for _, bulk := range []bool{false, true} {
+		mode := "Single"
+		if bulk {
+			mode = "Bulk"
+		}
+		t.Run(mode, func(t *testing.T) {
+			t.Run("WriteRead", func(t *testing.T) { testWriteRead(t, open(t, newStrategy, bulk), opts) })
+			t.Run("ReadMany", func(t *testing.T) { testReadMany(t, open(t, newStrategy, bulk), opts) })
+			t.Run("UpdateField", func(t *testing.T) { testUpdateField(t, open(t, newStrategy, bulk), opts) })
+			t.Run("ReadFieldSum", func(t *testing.T) { testReadFieldSum(t, open(t, newStrategy, bulk), opts) })
+			t.Run("Verify", func(t *testing.T) { testVerify(t, open(t, newStrategy, bulk)) })
+		})
+	}
+}
+
+// store is a strategy with Records written to its own database, and the
+// reference copy of what it should hold.
+type store struct {
+	s    strategy.StorageStrategy
+	db   *bbolt.DB
+	want []*strategy.UserInfo
+}
+
+func open(t *testing.T, newStrategy func() strategy.StorageStrategy, bulk bool) *store {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "conformance.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	v := &strategy.StrategyVariant{Strategy: newStrategy(), Bulk: bulk}
+	if err := v.Setup(db); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	// Write a copy, so a strategy that keeps or changes its input cannot
+	// affect the reference.
+	records := Records()
+	if err := v.WriteAll(db, Records()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return &store{s: v.Strategy, db: db, want: records}
+}
+
+// missingID returns an ID no record has.
+func (s *store) missingID() int64 { return s.want[len(s.want)-1].ID + 1 }
+
+// scrub returns a copy of u with the lossy fields zeroed.
+func scrub(u *strategy.UserInfo, opts Options) strategy.UserInfo {
+	c := *u
+	for _, name := range opts.Lossy {
+		reflectField(&c, name).SetZero()
+	}
+	return c
+}
+
+func lossy(name string, opts Options) bool {
+	for _, l := range opts.Lossy {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+func checkEqual(t *testing.T, what string, got, want *strategy.UserInfo, opts Options) {
+	t.Helper()
+	if got == nil {
+		t.Errorf("%s: got nil record, want ID %d", what, want.ID)
+		return
+	}
+	if g, w := scrub(got, opts), scrub(want, opts); g != w {
+		t.Errorf("%s:\n got  %+v\n want %+v", what, g, w)
+	}
+}
+
+func testWriteRead(t *testing.T, s *store, opts Options) {
+	for _, want := range s.want {
+		got, err := s.s.Read(s.db, want.ID)
+		if err != nil {
+			t.Errorf("Read(%d): %v", want.ID, err)
+			continue
+		}
+		checkEqual(t, fmt.Sprintf("Read(%d)", want.ID), got, want, opts)
+	}
+	if got, err := s.s.Read(s.db, s.missingID()); err == nil {
+		t.Errorf("Read(%d) of a missing record = %+v, want an error", s.missingID(), got)
+	}
+}
+
+func testReadMany(t *testing.T, s *store, opts Options) {
+	n := len(s.want)
+	cases := []struct {
+		name    string
+		startID int64
+		count   int
+		want    []*strategy.UserInfo
+	}{
+		{"all", s.want[0].ID, n, s.want},
+		{"past the end", s.want[0].ID, n + 10, s.want},
+		{"prefix", s.want[0].ID, 5, s.want[:5]},
+		{"middle", s.want[10].ID, 7, s.want[10:17]},
+		{"start between IDs", s.want[10].ID + 1, 3, s.want[11:14]},
+		{"tail", s.want[n-3].ID, 10, s.want[n-3:]},
+		{"after the last", s.missingID(), 5, nil},
+		{"zero count", s.want[5].ID, 0, nil},
+	}
+	for _, c := range cases {
+		got, err := s.s.ReadMany(s.db, c.startID, c.count)
+		if err != nil {
+			t.Errorf("%s: ReadMany(%d, %d): %v", c.name, c.startID, c.count, err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: ReadMany(%d, %d) returned %d records, want %d", c.name, c.startID, c.count, len(got), len(c.want))
+			continue
+		}
+		for i := range got {
+			checkEqual(t, fmt.Sprintf("%s: ReadMany(%d, %d)[%d]", c.name, c.startID, c.count, i), got[i], c.want[i], opts)
+		}
+	}
+}
+
+func testUpdateField(t *testing.T, s *store, opts Options) {
+	target := s.want[len(s.want)/2]
+	for _, f := range numericFields {
+		if lossy(f.name, opts) {
+			continue
+		}
+		if err := s.s.UpdateField(s.db, target.ID, f.name, f.value); err != nil {
+			t.Errorf("UpdateField(%d, %s): %v", target.ID, f.name, err)
+			continue
+		}
+		reflectField(target, f.name).Set(reflect.ValueOf(f.value))
+	}
+
+	if err := s.s.UpdateField(s.db, s.missingID(), "balance", 1.5); err == nil {
+		t.Errorf("UpdateField(%d) of a missing record succeeded, want an error", s.missingID())
+	}
+	if got, err := s.s.Read(s.db, s.missingID()); err == nil {
+		t.Errorf("UpdateField(%d) of a missing record created %+v", s.missingID(), got)
+	}
+
+	// The ID is the key; an update must fail or leave it alone.
+	s.s.UpdateField(s.db, target.ID, "id", s.missingID())
+
+	// Every other record and field must be as written.
+	for _, want := range s.want {
+		got, err := s.s.Read(s.db, want.ID)
+		if err != nil {
+			t.Errorf("Read(%d): %v", want.ID, err)
+			continue
+		}
+		checkEqual(t, fmt.Sprintf("Read(%d) after updating %d", want.ID, target.ID), got, want, opts)
+	}
+}
+
+// reflectField returns the field of u whose json name is name.
+func reflectField(u *strategy.UserInfo, name string) reflect.Value {
+	v := reflect.ValueOf(u).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("json") == name {
+			return v.Field(i)
+		}
+	}
+	panic("conformance: no field " + name)
+}
+
+func testReadFieldSum(t *testing.T, s *store, opts Options) {
+	// ReadFieldSum visits records in key order, which for big-endian IDs is
+	// ID order.
+	sorted := append([]*strategy.UserInfo(nil), s.want...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	for _, f := range numericFields {
+		if lossy(f.name, opts) {
+			continue
+		}
+		for _, count := range []int{len(sorted), len(sorted) / 2, 1, len(sorted) + 10} {
+			var want float64
+			for _, u := range sorted[:min(count, len(sorted))] {
+				want += reflectField(u, f.name).Convert(reflect.TypeOf(float64(0))).Float()
+			}
+			got, err := s.s.ReadFieldSum(s.db, f.name, count)
+			if err != nil {
+				t.Errorf("ReadFieldSum(%s, %d): %v", f.name, count, err)
+				continue
+			}
+			if math.Abs(got-want) > 1e-9*math.Max(1, math.Abs(want)) {
+				t.Errorf("ReadFieldSum(%s, %d) = %v, want %v", f.name, count, got, want)
+			}
+		}
+	}
+	if _, err := s.s.ReadFieldSum(s.db, "username", len(sorted)); err == nil {
+		t.Errorf("ReadFieldSum(username) succeeded, want an error")
+	}
+}
+
+func testVerify(t *testing.T, s *store) {
+	if _, ok := s.s.(strategy.Verifier); !ok {
+		t.Skipf("%s does not implement Verifier", s.s.Name())
+	}
+	err := strategy.Verify(s.db, s.s, func(id int64, err error) {
+		t.Errorf("Verify reported record %d: %v", id, err)
+	})
+	if err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
@@ -1,5 +1,7 @@
 package strategy
 
+//go:generate go run ../cmd/bingen -type UserInfo
+
 // An example struct that has many fields
 type UserInfo struct {
 	ID          int64   `json:"id"`
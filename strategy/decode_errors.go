@@ -0,0 +1,40 @@
+package strategy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTruncated is returned by the hand-written decoders for a record that
+// ends in the middle of a field.
+var ErrTruncated = errors.New("truncated record")
+
+// ErrBadBool is returned for a bool byte other than 0 or 1.
+var ErrBadBool = errors.New("bool is not 0 or 1")
+
+// ErrTrailingData is returned for a record with bytes left over after its
+// last field.
+var ErrTrailingData = errors.New("trailing data")
+
+// LengthError reports a length prefix that is negative or longer than the
+// rest of the record.
+type LengthError struct {
+	Field     string
+	Length    int64
+	Remaining int
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("%s: length %d exceeds %d remaining bytes", e.Field, e.Length, e.Remaining)
+}
+
+// TagError reports a Binary+Names type tag that is unknown or does not
+// match its field.
+type TagError struct {
+	Field string
+	Tag   byte
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("%s: bad type tag %d", e.Field, e.Tag)
+}
@@ -0,0 +1,435 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// 22. Delta strategy
+//
+// Consecutive records differ in little more than their numeric suffixes and
+// random numbers. Records whose ID is a multiple of AnchorEvery are anchors
+// and are stored in full; every other record is stored as a delta against
+// the anchor of its group, the nearest lower multiple:
+//
+//	full:  0 | the fields as in BinaryStrategy, in declaration order
+//	delta: 1 | uvarint bitmask of changed fields | one entry per changed field
+//
+// A numeric or bool entry is the uvarint XOR of the field's bits with the
+// anchor's; a string entry is the uvarint lengths of the prefix and suffix
+// shared with the anchor's value, then the uvarint length and bytes of what
+// lies between. A record whose anchor is missing, or whose delta would not
+// be smaller, is stored in full. Rewriting an anchor rebases the records of
+// its group onto the new value.
+//
+// A delta does not name its anchor, so the group size is part of the
+// format: Setup stores it in users_delta_meta, and Setup and Verify fail
+// on a database written with a different one.
+type DeltaStrategy struct {
+	// AnchorEvery is the group size. Defaults to 16.
+	AnchorEvery int
+}
+
+const (
+	deltaFull = byte(0)
+	deltaDiff = byte(1)
+)
+
+var deltaAnchorEveryKey = []byte("anchor_every")
+
+func (s *DeltaStrategy) anchorEvery() int64 {
+	if s.AnchorEvery <= 0 {
+		return 16
+	}
+	return int64(s.AnchorEvery)
+}
+
+func (s *DeltaStrategy) Name() string { return fmt.Sprintf("Delta(%d)", s.anchorEvery()) }
+
+// anchorOf returns the ID of the anchor of id's group.
+func (s *DeltaStrategy) anchorOf(id int64) int64 {
+	k := s.anchorEvery()
+	return id - (id%k+k)%k
+}
+
+func (s *DeltaStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("users_delta")); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte("users_delta_meta"))
+		if err != nil {
+			return err
+		}
+		if meta.Get(deltaAnchorEveryKey) == nil {
+			return meta.Put(deltaAnchorEveryKey, binary.BigEndian.AppendUint64(nil, uint64(s.anchorEvery())))
+		}
+		return s.checkAnchorEvery(meta)
+	})
+}
+
+// checkAnchorEvery fails if meta records a group size other than s's.
+func (s *DeltaStrategy) checkAnchorEvery(meta *bbolt.Bucket) error {
+	v := meta.Get(deltaAnchorEveryKey)
+	if v == nil {
+		return nil
+	}
+	if len(v) != 8 {
+		return fmt.Errorf("delta: bad stored AnchorEvery %x", v)
+	}
+	if stored := int64(binary.BigEndian.Uint64(v)); stored != s.anchorEvery() {
+		return fmt.Errorf("delta: database was written with AnchorEvery %d, not %d", stored, s.anchorEvery())
+	}
+	return nil
+}
+
+func encodeDeltaFull(user *UserInfo) []byte {
+	buf := []byte{deltaFull}
+	for i := range userFields {
+		buf = appendPackedField(buf, &userFields[i], user)
+	}
+	return buf
+}
+
+func decodeDeltaFull(data []byte) (*UserInfo, error) {
+	user := &UserInfo{}
+	rest := data[1:]
+	for i := range userFields {
+		var err error
+		if rest, err = decodePackedField(rest, &userFields[i], user); err != nil {
+			return nil, err
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("delta: %d trailing bytes", len(rest))
+	}
+	return user, nil
+}
+
+// leUint reads a little-endian integer of len(b) bytes.
+func leUint(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func commonSuffix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+// encodeDelta encodes user against anchor, falling back to the full form
+// when that is not larger.
+func encodeDelta(anchor, user *UserInfo) []byte {
+	var mask uint64
+	var body, a, u []byte
+	for i := range userFields {
+		f := &userFields[i]
+		a = f.appendValue(a[:0], anchor)
+		u = f.appendValue(u[:0], user)
+		if bytes.Equal(a, u) {
+			continue
+		}
+		mask |= 1 << i
+		if f.tag != tagString {
+			body = binary.AppendUvarint(body, leUint(a)^leUint(u))
+			continue
+		}
+		p := commonPrefix(a, u)
+		q := commonSuffix(a[p:], u[p:])
+		body = binary.AppendUvarint(body, uint64(p))
+		body = binary.AppendUvarint(body, uint64(q))
+		body = binary.AppendUvarint(body, uint64(len(u)-p-q))
+		body = append(body, u[p:len(u)-q]...)
+	}
+
+	full := encodeDeltaFull(user)
+	buf := binary.AppendUvarint([]byte{deltaDiff}, mask)
+	if len(buf)+len(body) >= len(full) {
+		return full
+	}
+	return append(buf, body...)
+}
+
+// applyDelta decodes a delta written by encodeDelta against anchor, which is
+// left unchanged.
+func applyDelta(data []byte, anchor *UserInfo) (*UserInfo, error) {
+	mask, n := binary.Uvarint(data[1:])
+	if n <= 0 || mask>>len(userFields) != 0 {
+		return nil, fmt.Errorf("delta: bad field mask")
+	}
+	rest := data[1+n:]
+	next := func(what string) (uint64, error) {
+		v, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return 0, fmt.Errorf("delta: read %s: truncated", what)
+		}
+		rest = rest[n:]
+		return v, nil
+	}
+
+	user := *anchor
+	var a, v []byte
+	for i := range userFields {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		f := &userFields[i]
+		a = f.appendValue(a[:0], anchor)
+		if f.tag != tagString {
+			x, err := next(f.name)
+			if err != nil {
+				return nil, err
+			}
+			x ^= leUint(a)
+			if len(a) < 8 && x>>(8*len(a)) != 0 {
+				return nil, fmt.Errorf("delta: %s out of range", f.name)
+			}
+			v = binary.LittleEndian.AppendUint64(v[:0], x)[:len(a)]
+		} else {
+			p, err := next(f.name)
+			if err != nil {
+				return nil, err
+			}
+			q, err := next(f.name)
+			if err != nil {
+				return nil, err
+			}
+			m, err := next(f.name)
+			if err != nil {
+				return nil, err
+			}
+			if p > uint64(len(a)) || q > uint64(len(a))-p || m > uint64(len(rest)) {
+				return nil, fmt.Errorf("delta: %s: bad lengths", f.name)
+			}
+			v = append(append(append(v[:0], a[:p]...), rest[:m]...), a[uint64(len(a))-q:]...)
+			rest = rest[m:]
+		}
+		if err := f.decodeValue(v, &user); err != nil {
+			return nil, err
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("delta: %d trailing bytes", len(rest))
+	}
+	return &user, nil
+}
+
+// deltaReader decodes the values of one transaction, keeping the last
+// anchor it decoded so that a run of records of the same group resolves
+// its anchor once.
+type deltaReader struct {
+	s        *DeltaStrategy
+	b        *bbolt.Bucket
+	anchorID int64
+	anchor   *UserInfo
+}
+
+func (s *DeltaStrategy) newReader(b *bbolt.Bucket) *deltaReader {
+	return &deltaReader{s: s, b: b}
+}
+
+func (r *deltaReader) decode(id int64, data []byte) (*UserInfo, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("delta: empty value")
+	}
+	switch data[0] {
+	case deltaFull:
+		user, err := decodeDeltaFull(data)
+		if err == nil && r.s.anchorOf(id) == id {
+			r.anchorID, r.anchor = id, user
+		}
+		return user, err
+	case deltaDiff:
+		anchorID := r.s.anchorOf(id)
+		if r.anchor == nil || r.anchorID != anchorID {
+			anchorData := r.b.Get(idKey(anchorID))
+			if anchorData == nil {
+				return nil, fmt.Errorf("delta: anchor %d of user %d not found", anchorID, id)
+			}
+			if len(anchorData) == 0 || anchorData[0] != deltaFull {
+				return nil, fmt.Errorf("delta: anchor %d is not stored in full", anchorID)
+			}
+			anchor, err := decodeDeltaFull(anchorData)
+			if err != nil {
+				return nil, err
+			}
+			r.anchorID, r.anchor = anchorID, anchor
+		}
+		return applyDelta(data, r.anchor)
+	}
+	return nil, fmt.Errorf("delta: unknown record kind %d", data[0])
+}
+
+// put stores user under id, as a delta if its anchor is stored. Storing an
+// anchor re-encodes the rest of its group against the new value.
+func (s *DeltaStrategy) put(b *bbolt.Bucket, id int64, user *UserInfo) error {
+	anchorID := s.anchorOf(id)
+	if anchorID != id {
+		data := b.Get(idKey(anchorID))
+		if len(data) == 0 || data[0] != deltaFull {
+			return b.Put(idKey(id), encodeDeltaFull(user))
+		}
+		anchor, err := decodeDeltaFull(data)
+		if err != nil {
+			return err
+		}
+		return b.Put(idKey(id), encodeDelta(anchor, user))
+	}
+
+	// Decode the group against the old anchor before replacing it.
+	type member struct {
+		id   int64
+		user *UserInfo
+	}
+	var group []member
+	if s.anchorEvery() > 1 {
+		r := s.newReader(b)
+		c := b.Cursor()
+		for k, v := seekNextID(c, uint64(id)); k != nil; k, v = c.Next() {
+			mid := int64(binary.BigEndian.Uint64(k))
+			if s.anchorOf(mid) != id {
+				break
+			}
+			m, err := r.decode(mid, v)
+			if err != nil {
+				return err
+			}
+			group = append(group, member{mid, m})
+		}
+	}
+
+	if err := b.Put(idKey(id), encodeDeltaFull(user)); err != nil {
+		return err
+	}
+	for _, m := range group {
+		if err := b.Put(idKey(m.id), encodeDelta(user, m.user)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DeltaStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		return s.put(tx.Bucket([]byte("users_delta")), user.ID, user)
+	})
+}
+
+func (s *DeltaStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_delta"))
+		for _, user := range users {
+			if err := s.put(b, user.ID, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *DeltaStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_delta"))
+		data := b.Get(idKey(id))
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.newReader(b).decode(id, data)
+		return err
+	})
+	return user, err
+}
+
+func (s *DeltaStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_delta"))
+		r := s.newReader(b)
+		c := b.Cursor()
+
+		for k, v := c.Seek(idKey(startId)); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := r.decode(int64(binary.BigEndian.Uint64(k)), v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *DeltaStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_delta"))
+		data := b.Get(idKey(id))
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+
+		user, err := s.newReader(b).decode(id, data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		return s.put(b, id, user)
+	})
+}
+
+func (s *DeltaStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_delta"))
+		r := s.newReader(b)
+		c := b.Cursor()
+		processed := 0
+
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			user, err := r.decode(int64(binary.BigEndian.Uint64(k)), v)
+			if err != nil {
+				return err
+			}
+			n, _ := userFieldNumber(user, fieldName)
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *DeltaStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	err := db.View(func(tx *bbolt.Tx) error {
+		if meta := tx.Bucket([]byte("users_delta_meta")); meta != nil {
+			return s.checkAnchorEvery(meta)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return verifyByRead[UserInfo](db, s, []byte("users_delta"), corrupt)
+}
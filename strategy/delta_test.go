@@ -0,0 +1,44 @@
+package strategy_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "boltdb_benchmarks/strategy"
+	"go.etcd.io/bbolt"
+)
+
+// Deltas do not name their anchor, so reading them with another group size
+// would silently return wrong records.
+func TestDeltaRejectsOtherAnchorEvery(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "delta.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	w := &DeltaStrategy{AnchorEvery: 16}
+	if err := w.Setup(db); err != nil {
+		t.Fatal(err)
+	}
+	users := make([]*UserInfo, 64)
+	for i := range users {
+		users[i] = &UserInfo{ID: int64(i), Age: int32(i), Username: "user"}
+	}
+	if err := w.WriteMany(db, users); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &DeltaStrategy{AnchorEvery: 32}
+	if err := r.Setup(db); err == nil {
+		t.Error("Setup with AnchorEvery 32 succeeded on a database written with 16")
+	}
+	if err := Verify(db, r, func(int64, error) {}); err == nil {
+		t.Error("Verify with AnchorEvery 32 succeeded on a database written with 16")
+	}
+	if err := (&DeltaStrategy{AnchorEvery: 16}).Setup(db); err != nil {
+		t.Errorf("Setup with the same AnchorEvery: %v", err)
+	}
+	if err := Verify(db, w, func(id int64, err error) { t.Errorf("record %d: %v", id, err) }); err != nil {
+		t.Errorf("Verify with the same AnchorEvery: %v", err)
+	}
+}
@@ -0,0 +1,448 @@
+package strategy
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"sync"
+)
+
+// 12. Shared-dictionary compression strategy
+//
+// Single records are too small to compress well on their own, but they are
+// very similar to each other. A preset flate dictionary is trained from a
+// sample of records and stored in the meta bucket under its own ID; every
+// value starts with the uvarint ID of the dictionary it was compressed
+// with, so dictionaries can be retrained without rewriting old records.
+//
+// Until SampleSize records are available, values are compressed with an
+// empty dictionary. The first write that brings the stored and pending
+// records to SampleSize trains the real one from them.
+type DictCompressedStrategy struct {
+	Inner StorageStrategy
+	// Level is the flate compression level, from flate.HuffmanOnly to
+	// flate.BestCompression. Zero selects 6; flate.NoCompression is
+	// spelled DictNoCompression.
+	Level int
+	// SampleSize is the number of records a dictionary is trained from.
+	// Defaults to 64.
+	SampleSize int
+
+	mu      sync.Mutex
+	writers map[uint64]*dictWriters // keyed by dictionary ID
+	readers sync.Pool
+}
+
+// DictNoCompression is the Level for flate.NoCompression, whose value 0
+// already means the default.
+const DictNoCompression = -100
+
+// dictWriters pools the flate writers of one dictionary. IDs are only
+// unique within a database, so the pool remembers which dictionary it is
+// for.
+type dictWriters struct {
+	dict []byte
+	pool sync.Pool
+}
+
+// maxDictSize is the flate window size; a longer dictionary is truncated
+// to its tail.
+const maxDictSize = 32 << 10
+
+var (
+	dictCurrentKey = []byte("current")
+	dictKeyPrefix  = []byte("dict/")
+)
+
+func (s *DictCompressedStrategy) Name() string { return s.Inner.Name() + "+dict" }
+
+func (s *DictCompressedStrategy) level() int {
+	switch s.Level {
+	case 0:
+		return 6
+	case DictNoCompression:
+		return flate.NoCompression
+	}
+	return s.Level
+}
+
+func (s *DictCompressedStrategy) sampleSize() int {
+	if s.SampleSize <= 0 {
+		return 64
+	}
+	return s.SampleSize
+}
+
+func (s *DictCompressedStrategy) Setup(db *bbolt.DB) error {
+	if _, err := asValueCodec(s.Inner); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("users_dict")); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte("users_dict_meta"))
+		return err
+	})
+}
+
+func dictKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), dictKeyPrefix...), id)
+}
+
+// trainDict builds a dictionary from evenly spaced records of sample. The
+// most useful material should be closest to the data, so later samples end
+// up at the tail of the dictionary.
+func (s *DictCompressedStrategy) trainDict(inner valueCodec, sample []*UserInfo) ([]byte, error) {
+	step := 1
+	if len(sample) > s.sampleSize() {
+		step = len(sample) / s.sampleSize()
+	}
+	var dict []byte
+	for i := 0; i < len(sample); i += step {
+		data, err := inner.encodeValue(sample[i])
+		if err != nil {
+			return nil, err
+		}
+		dict = append(dict, data...)
+	}
+	if len(dict) > maxDictSize {
+		dict = dict[len(dict)-maxDictSize:]
+	}
+	return dict, nil
+}
+
+// storeDict saves dict under a new ID and makes it the current dictionary.
+func (s *DictCompressedStrategy) storeDict(meta *bbolt.Bucket, dict []byte) (uint64, error) {
+	id, err := meta.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if err := meta.Put(dictKey(id), dict); err != nil {
+		return 0, err
+	}
+	return id, meta.Put(dictCurrentKey, binary.BigEndian.AppendUint64(nil, id))
+}
+
+// currentDict returns the dictionary new values are compressed with. As
+// long as there is only the empty one, a dictionary is trained as soon as
+// the records in b and pending reach the sample size.
+func (s *DictCompressedStrategy) currentDict(meta, b *bbolt.Bucket, inner valueCodec, pending []*UserInfo) (uint64, []byte, error) {
+	cur := meta.Get(dictCurrentKey)
+	if cur != nil {
+		id := binary.BigEndian.Uint64(cur)
+		dict := meta.Get(dictKey(id))
+		if dict == nil {
+			return 0, nil, fmt.Errorf("dictionary %d not found", id)
+		}
+		if len(dict) > 0 {
+			return id, dict, nil
+		}
+	}
+
+	sample, err := s.storedSample(meta, b, s.sampleSize()-len(pending))
+	if err != nil {
+		return 0, nil, err
+	}
+	sample = append(sample, pending...)
+	var dict []byte
+	if len(sample) >= s.sampleSize() {
+		if dict, err = s.trainDict(inner, sample); err != nil {
+			return 0, nil, err
+		}
+	} else if cur != nil {
+		return binary.BigEndian.Uint64(cur), nil, nil
+	}
+	id, err := s.storeDict(meta, dict)
+	return id, dict, err
+}
+
+// storedSample decodes up to n of the records already in b.
+func (s *DictCompressedStrategy) storedSample(meta, b *bbolt.Bucket, n int) ([]*UserInfo, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return nil, err
+	}
+	r := &dictReader{s: s, inner: inner, meta: meta, dicts: map[uint64][]byte{}}
+	var sample []*UserInfo
+	c := b.Cursor()
+	for k, v := c.First(); k != nil && len(sample) < n; k, v = c.Next() {
+		user, err := r.decode(v)
+		if err != nil {
+			return nil, err
+		}
+		sample = append(sample, user)
+	}
+	return sample, nil
+}
+
+// Retrain builds a new dictionary from sample and makes it current. Values
+// written earlier keep decoding with the dictionary they reference.
+func (s *DictCompressedStrategy) Retrain(db *bbolt.DB, sample []*UserInfo) (uint64, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return 0, err
+	}
+	dict, err := s.trainDict(inner, sample)
+	if err != nil {
+		return 0, err
+	}
+	var id uint64
+	err = db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		id, err = s.storeDict(tx.Bucket([]byte("users_dict_meta")), dict)
+		return err
+	})
+	return id, err
+}
+
+// writerPool returns the writers for dictionary id, whose contents are
+// dict.
+func (s *DictCompressedStrategy) writerPool(id uint64, dict []byte) *sync.Pool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.writers[id]; ok && bytes.Equal(w.dict, dict) {
+		return &w.pool
+	}
+	if s.writers == nil {
+		s.writers = make(map[uint64]*dictWriters)
+	}
+	w := &dictWriters{dict: append([]byte(nil), dict...)}
+	s.writers[id] = w
+	return &w.pool
+}
+
+func (s *DictCompressedStrategy) encode(inner valueCodec, user *UserInfo, id uint64, dict []byte, pool *sync.Pool) ([]byte, error) {
+	raw, err := inner.encodeValue(user)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(binary.AppendUvarint(nil, id))
+
+	w, _ := pool.Get().(*flate.Writer)
+	if w == nil {
+		if w, err = flate.NewWriterDict(buf, s.level(), dict); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(buf)
+	}
+	defer pool.Put(w)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dictReader decodes values of one transaction, loading each dictionary
+// from the meta bucket at most once.
+type dictReader struct {
+	s     *DictCompressedStrategy
+	inner valueCodec
+	meta  *bbolt.Bucket
+	dicts map[uint64][]byte
+}
+
+func (s *DictCompressedStrategy) newReader(tx *bbolt.Tx) (*dictReader, error) {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return nil, err
+	}
+	return &dictReader{s: s, inner: inner, meta: tx.Bucket([]byte("users_dict_meta")), dicts: map[uint64][]byte{}}, nil
+}
+
+func (r *dictReader) decode(data []byte) (*UserInfo, error) {
+	id, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("dict: bad dictionary id")
+	}
+	dict, ok := r.dicts[id]
+	if !ok {
+		if dict = r.meta.Get(dictKey(id)); dict == nil {
+			return nil, fmt.Errorf("dictionary %d not found", id)
+		}
+		r.dicts[id] = dict
+	}
+
+	src := bytes.NewReader(data[n:])
+	fr, _ := r.s.readers.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReaderDict(src, dict)
+	} else if err := fr.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+	defer r.s.readers.Put(fr)
+	raw, err := io.ReadAll(fr)
+	if err != nil {
+		return nil, fmt.Errorf("dict %d decompress: %w", id, err)
+	}
+	return r.inner.decodeValue(raw)
+}
+
+func (s *DictCompressedStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_dict"))
+		id, dict, err := s.currentDict(tx.Bucket([]byte("users_dict_meta")), b, inner, []*UserInfo{user})
+		if err != nil {
+			return err
+		}
+		data, err := s.encode(inner, user, id, dict, s.writerPool(id, dict))
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, data)
+	})
+}
+
+func (s *DictCompressedStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_dict"))
+		id, dict, err := s.currentDict(tx.Bucket([]byte("users_dict_meta")), b, inner, users)
+		if err != nil {
+			return err
+		}
+		pool := s.writerPool(id, dict)
+		for _, user := range users {
+			data, err := s.encode(inner, user, id, dict, pool)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *DictCompressedStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		r, err := s.newReader(tx)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := tx.Bucket([]byte("users_dict")).Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err = r.decode(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *DictCompressedStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		r, err := s.newReader(tx)
+		if err != nil {
+			return err
+		}
+		c := tx.Bucket([]byte("users_dict")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := r.decode(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *DictCompressedStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	inner, err := asValueCodec(s.Inner)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		r, err := s.newReader(tx)
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket([]byte("users_dict"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err := r.decode(data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+
+		// Rewritten values move to the current dictionary.
+		dictID, dict, err := s.currentDict(r.meta, b, inner, nil)
+		if err != nil {
+			return err
+		}
+		newData, err := s.encode(inner, user, dictID, dict, s.writerPool(dictID, dict))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *DictCompressedStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		r, err := s.newReader(tx)
+		if err != nil {
+			return err
+		}
+		c := tx.Bucket([]byte("users_dict")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			user, err := r.decode(v)
+			if err != nil {
+				return err
+			}
+			n, err := userFieldNumber(user, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *DictCompressedStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_dict"), corrupt)
+}
@@ -0,0 +1,57 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// Single writes must end up with a dictionary trained from a full sample,
+// not from the first record alone.
+func TestDictTrainsFromSample(t *testing.T) {
+	s := &DictCompressedStrategy{Inner: &JSONStrategy{}, SampleSize: 8, Level: DictNoCompression}
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "dict.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := s.Setup(db); err != nil {
+		t.Fatal(err)
+	}
+
+	current := func() []byte {
+		var dict []byte
+		db.View(func(tx *bbolt.Tx) error {
+			meta := tx.Bucket([]byte("users_dict_meta"))
+			if cur := meta.Get(dictCurrentKey); cur != nil {
+				dict = append([]byte{}, meta.Get(dictKey(binary.BigEndian.Uint64(cur)))...)
+			}
+			return nil
+		})
+		return dict
+	}
+	for i := int64(0); i < 12; i++ {
+		user := &UserInfo{ID: i, Username: fmt.Sprintf("user_%d", i)}
+		if err := s.Write(db, user); err != nil {
+			t.Fatal(err)
+		}
+		if dict := current(); i < 7 && len(dict) != 0 {
+			t.Fatalf("after %d records: dictionary of %d bytes, want none before the sample is complete", i+1, len(dict))
+		}
+	}
+	dict := current()
+	for i := 0; i < 8; i++ {
+		if name := fmt.Sprintf(`"user_%d"`, i); !bytes.Contains(dict, []byte(name)) {
+			t.Errorf("dictionary lacks record %d", i)
+		}
+	}
+	for i := int64(0); i < 12; i++ {
+		if u, err := s.Read(db, i); err != nil || u.Username != fmt.Sprintf("user_%d", i) {
+			t.Errorf("Read(%d) = %+v, %v", i, u, err)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package strategy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"os"
+	"strings"
+)
+
+// 25. Encryption decorator
+//
+// Wraps a strategy that stores one value per record and encrypts each value
+// with AES-GCM before it is stored:
+//
+//	12-byte random nonce | ciphertext | 16-byte tag
+//
+// The record's 8-byte key is the associated data, so a value copied under
+// another ID fails to decrypt instead of being read as that user.
+type EncryptedStrategy struct {
+	Inner StorageStrategy
+	Key   *EncryptionKey
+}
+
+// EncryptionKey is an AES-GCM key.
+type EncryptionKey struct {
+	aead cipher.AEAD
+}
+
+// Environment variables LoadEncryptionKey reads the key from.
+const (
+	EncryptionKeyEnv     = "BENCH_ENCRYPTION_KEY"
+	EncryptionKeyFileEnv = "BENCH_ENCRYPTION_KEY_FILE"
+)
+
+// ErrNoEncryptionKey is returned by LoadEncryptionKey when neither
+// environment variable is set.
+var ErrNoEncryptionKey = errors.New("no encryption key: set " + EncryptionKeyEnv + " or " + EncryptionKeyFileEnv)
+
+// NewEncryptionKey returns an AES-128, AES-192 or AES-256 key, depending
+// on whether key is 16, 24 or 32 bytes long.
+func NewEncryptionKey(key []byte) (*EncryptionKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptionKey{aead: aead}, nil
+}
+
+// NewEphemeralKey returns a random AES-256 key. Whatever it encrypts is
+// unreadable once the key is gone.
+func NewEphemeralKey() (*EncryptionKey, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return NewEncryptionKey(key)
+}
+
+// LoadEncryptionKey reads the key from $BENCH_ENCRYPTION_KEY, hex encoded,
+// or else from the file named by $BENCH_ENCRYPTION_KEY_FILE, which holds
+// either the hex encoding or the raw key bytes.
+func LoadEncryptionKey() (*EncryptionKey, error) {
+	if s := os.Getenv(EncryptionKeyEnv); s != "" {
+		key, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EncryptionKeyEnv, err)
+		}
+		return NewEncryptionKey(key)
+	}
+	if path := os.Getenv(EncryptionKeyFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if key, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil {
+			data = key
+		}
+		k, err := NewEncryptionKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return k, nil
+	}
+	return nil, ErrNoEncryptionKey
+}
+
+// seal encrypts data, binding it to ad.
+func (k *EncryptionKey) seal(data, ad []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	buf := make([]byte, n, n+len(data)+k.aead.Overhead())
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(buf, buf, data, ad), nil
+}
+
+// open decrypts a value written by seal with the same ad.
+func (k *EncryptionKey) open(data, ad []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(data) < n+k.aead.Overhead() {
+		return nil, fmt.Errorf("aes-gcm: value too short")
+	}
+	plain, err := k.aead.Open(nil, data[:n], data[n:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("aes-gcm: %w", err)
+	}
+	return plain, nil
+}
+
+func (s *EncryptedStrategy) Name() string {
+	return s.Inner.Name() + "+aes-gcm"
+}
+
+// Unwrap returns the strategy whose values are encrypted.
+func (s *EncryptedStrategy) Unwrap() StorageStrategy { return s.Inner }
+
+func (s *EncryptedStrategy) codec() (valueCodec, error) {
+	if s.Key == nil {
+		return nil, fmt.Errorf("%s: no encryption key", s.Name())
+	}
+	return asValueCodec(s.Inner)
+}
+
+func (s *EncryptedStrategy) Setup(db *bbolt.DB) error {
+	if _, err := s.codec(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_encrypted"))
+		return err
+	})
+}
+
+func (s *EncryptedStrategy) encode(inner valueCodec, key []byte, user *UserInfo) ([]byte, error) {
+	data, err := inner.encodeValue(user)
+	if err != nil {
+		return nil, err
+	}
+	return s.Key.seal(data, key)
+}
+
+func (s *EncryptedStrategy) decode(inner valueCodec, key, data []byte) (*UserInfo, error) {
+	raw, err := s.Key.open(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("user %d: %w", binary.BigEndian.Uint64(key), err)
+	}
+	return inner.decodeValue(raw)
+}
+
+func (s *EncryptedStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	inner, err := s.codec()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_encrypted"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		data, err := s.encode(inner, key, user)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+}
+
+func (s *EncryptedStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	inner, err := s.codec()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_encrypted"))
+		for _, user := range users {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			data, err := s.encode(inner, key, user)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *EncryptedStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	inner, err := s.codec()
+	if err != nil {
+		return nil, err
+	}
+	var user *UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_encrypted"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decode(inner, key, data)
+		return err
+	})
+	return user, err
+}
+
+func (s *EncryptedStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	inner, err := s.codec()
+	if err != nil {
+		return nil, err
+	}
+	var users []*UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_encrypted")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decode(inner, k, v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *EncryptedStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	inner, err := s.codec()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_encrypted"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err := s.decode(inner, key, data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		newData, err := s.encode(inner, key, user)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *EncryptedStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	inner, err := s.codec()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_encrypted")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			user, err := s.decode(inner, k, v)
+			if err != nil {
+				return err
+			}
+			n, _ := userFieldNumber(user, fieldName)
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *EncryptedStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	if _, err := s.codec(); err != nil {
+		return err
+	}
+	return verifyByRead[UserInfo](db, s, []byte("users_encrypted"), corrupt)
+}
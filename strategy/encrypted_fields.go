@@ -0,0 +1,310 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"strings"
+	"sync"
+)
+
+// 26. Per-field encryption
+//
+// Stores UserInfo in the Reflect MultiKV or NestedBucket layout with only
+// the selected fields encrypted, as in EncryptedStrategy. The associated
+// data of a value is the record's 8-byte ID followed by the field name, so
+// values can be swapped neither between records nor between fields. The
+// other fields stay in the clear and are read without decrypting anything.
+type FieldEncryptedStrategy struct {
+	Layout Layout // LayoutMultiKV or LayoutNestedBucket
+	Fields []string
+	Key    *EncryptionKey
+
+	once      sync.Once
+	codec     *StructCodec
+	encrypted map[string]bool
+	err       error
+}
+
+func (s *FieldEncryptedStrategy) Name() string {
+	return s.Unwrap().Name() + "+aes-gcm(" + strings.Join(s.Fields, ",") + ")"
+}
+
+// Unwrap returns the same layout without encryption.
+func (s *FieldEncryptedStrategy) Unwrap() StorageStrategy { return &ReflectStrategy{Layout: s.Layout} }
+
+func (s *FieldEncryptedStrategy) init() error {
+	s.once.Do(func() {
+		if s.Layout != LayoutMultiKV && s.Layout != LayoutNestedBucket {
+			s.err = fmt.Errorf("per-field encryption does not support layout %v", s.Layout)
+			return
+		}
+		if s.Key == nil {
+			s.err = fmt.Errorf("%s: no encryption key", s.Name())
+			return
+		}
+		if s.codec, s.err = CodecFor(typeOf[UserInfo]()); s.err != nil {
+			return
+		}
+		s.encrypted = make(map[string]bool)
+		for _, name := range s.Fields {
+			if _, ok := s.codec.lookup(name); !ok {
+				s.err = fmt.Errorf("cannot encrypt unknown field %q", name)
+				return
+			}
+			s.encrypted[name] = true
+		}
+	})
+	return s.err
+}
+
+func (s *FieldEncryptedStrategy) bucket() []byte {
+	if s.Layout == LayoutNestedBucket {
+		return []byte("users_enc_nested")
+	}
+	return []byte("users_enc_multikv")
+}
+
+func (s *FieldEncryptedStrategy) Setup(db *bbolt.DB) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error { return setupFieldBucket(tx, s.bucket()) })
+}
+
+// fieldAD returns the associated data of a field value, which is also its
+// MultiKV key.
+func fieldAD(id int64, name string) []byte {
+	return append(idKey(id), name...)
+}
+
+// fieldKey returns the key of a field value in the bucket fieldBucket
+// returns.
+func (s *FieldEncryptedStrategy) fieldKey(id int64, name string) []byte {
+	if s.Layout == LayoutNestedBucket {
+		return []byte(name)
+	}
+	return fieldAD(id, name)
+}
+
+// fieldBucket returns the bucket the fields of record id are stored in, or
+// nil if there is no such record.
+func (s *FieldEncryptedStrategy) fieldBucket(root *bbolt.Bucket, id int64) *bbolt.Bucket {
+	if s.Layout == LayoutNestedBucket {
+		return root.Bucket(idKey(id))
+	}
+	key := idKey(id)
+	if k, _ := root.Cursor().Seek(key); k == nil || !bytes.HasPrefix(k, key) {
+		return nil
+	}
+	return root
+}
+
+func (s *FieldEncryptedStrategy) seal(id int64, name string, data []byte) ([]byte, error) {
+	if !s.encrypted[name] {
+		return data, nil
+	}
+	return s.Key.seal(data, fieldAD(id, name))
+}
+
+func (s *FieldEncryptedStrategy) open(id int64, name string, data []byte) ([]byte, error) {
+	if !s.encrypted[name] {
+		return data, nil
+	}
+	plain, err := s.Key.open(data, fieldAD(id, name))
+	if err != nil {
+		return nil, fmt.Errorf("user %d: %s: %w", id, name, err)
+	}
+	return plain, nil
+}
+
+func (s *FieldEncryptedStrategy) put(root *bbolt.Bucket, user *UserInfo) error {
+	b := root
+	if s.Layout == LayoutNestedBucket {
+		var err error
+		if b, err = root.CreateBucketIfNotExists(idKey(user.ID)); err != nil {
+			return err
+		}
+	}
+	return s.codec.storedValues(user, func(name string, data []byte) error {
+		data, err := s.seal(user.ID, name, data)
+		if err != nil {
+			return err
+		}
+		return b.Put(s.fieldKey(user.ID, name), data)
+	})
+}
+
+func (s *FieldEncryptedStrategy) get(root *bbolt.Bucket, id int64) (*UserInfo, error) {
+	b := root
+	if s.Layout == LayoutNestedBucket {
+		if b = root.Bucket(idKey(id)); b == nil {
+			return nil, fmt.Errorf("user %d not found", id)
+		}
+	}
+	var user *UserInfo
+	prefix := s.fieldKey(id, "")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if user == nil {
+			user = &UserInfo{}
+		}
+		name := string(k[len(prefix):])
+		data, err := s.open(id, name, v)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.codec.SetStored(user, name, data); err != nil {
+			return nil, err
+		}
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %d not found", id)
+	}
+	return user, nil
+}
+
+// each calls fn with the IDs of up to count records, starting at the key
+// start, or at the first record if start is nil.
+func (s *FieldEncryptedStrategy) each(root *bbolt.Bucket, start []byte, count int, fn func(id int64) error) error {
+	c := root.Cursor()
+	k, v := c.First()
+	if start != nil {
+		k, v = c.Seek(start)
+	}
+	for k != nil && count > 0 {
+		id := binary.BigEndian.Uint64(k)
+		if s.Layout == LayoutMultiKV {
+			// Skip past the record's remaining fields.
+			k, v = seekNextID(c, id)
+		} else {
+			skip := v != nil // not a record bucket
+			k, v = c.Next()
+			if skip {
+				continue
+			}
+		}
+		if err := fn(int64(id)); err != nil {
+			return err
+		}
+		count--
+	}
+	return nil
+}
+
+func (s *FieldEncryptedStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		return s.put(tx.Bucket(s.bucket()), user)
+	})
+}
+
+func (s *FieldEncryptedStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(s.bucket())
+		for _, user := range users {
+			if err := s.put(root, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *FieldEncryptedStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		var err error
+		user, err = s.get(tx.Bucket(s.bucket()), id)
+		return err
+	})
+	return user, err
+}
+
+func (s *FieldEncryptedStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(s.bucket())
+		return s.each(root, idKey(startId), count, func(id int64) error {
+			user, err := s.get(root, id)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *FieldEncryptedStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	data, err := s.codec.StoredValue(fieldName, value)
+	if err != nil {
+		return err
+	}
+	if data, err = s.seal(id, fieldName, data); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := s.fieldBucket(tx.Bucket(s.bucket()), id)
+		if b == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		return b.Put(s.fieldKey(id, fieldName), data)
+	})
+}
+
+func (s *FieldEncryptedStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if err := s.init(); err != nil {
+		return 0, err
+	}
+	if _, err := s.codec.FieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(s.bucket())
+		return s.each(root, nil, count, func(id int64) error {
+			b := root
+			if s.Layout == LayoutNestedBucket {
+				b = root.Bucket(idKey(id))
+			}
+			var user UserInfo
+			if v := b.Get(s.fieldKey(id, fieldName)); v != nil {
+				data, err := s.open(id, fieldName, v)
+				if err != nil {
+					return err
+				}
+				if err := s.codec.SetStored(&user, fieldName, data); err != nil {
+					return err
+				}
+			}
+			n, _ := s.codec.FieldNumber(&user, fieldName)
+			sum += n
+			return nil
+		})
+	})
+	return sum, err
+}
+
+func (s *FieldEncryptedStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	return verifyFieldsByRead[UserInfo](db, s, s.bucket(), metaBucket(s.bucket()), corrupt)
+}
@@ -0,0 +1,267 @@
+// Package fieldcodec encodes single field values, for the strategies that
+// store each field of a record under its own key.
+//
+// Every kind has one explicit, lossless encoding:
+//
+//	Int32, Int64      two's complement, 4 or 8 bytes little-endian
+//	Float32, Float64  IEEE 754 bits, 4 or 8 bytes little-endian
+//	Bool              one byte, 0 or 1
+//	String            the raw bytes
+//
+// A decoder accepts exactly what its encoder produces.
+//
+// Databases record the version of the encoding in a meta bucket, so that
+// data written with the legacy encoding (version 0) is detected instead of
+// misread; Legacy converts its values.
+package fieldcodec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+)
+
+// Kind is the type of a field value.
+type Kind byte
+
+const (
+	Int32 Kind = iota + 1
+	Int64
+	Float32
+	Float64
+	Bool
+	String
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Int32:
+		return "int32"
+	case Int64:
+		return "int64"
+	case Float32:
+		return "float32"
+	case Float64:
+		return "float64"
+	case Bool:
+		return "bool"
+	case String:
+		return "string"
+	}
+	return fmt.Sprintf("Kind(%d)", byte(k))
+}
+
+// Size returns the encoded size of a value of kind k, or -1 if it varies.
+func (k Kind) Size() int {
+	switch k {
+	case Int64, Float64:
+		return 8
+	case Int32, Float32:
+		return 4
+	case Bool:
+		return 1
+	}
+	return -1
+}
+
+// SizeError reports a value whose length does not match its kind.
+type SizeError struct {
+	Kind Kind
+	Got  int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("fieldcodec: %s: want %d bytes, got %d", e.Kind, e.Kind.Size(), e.Got)
+}
+
+// ErrBadBool is returned for a bool byte other than 0 or 1.
+var ErrBadBool = errors.New("fieldcodec: bool: not 0 or 1")
+
+func checkSize(k Kind, data []byte) error {
+	if len(data) != k.Size() {
+		return &SizeError{Kind: k, Got: len(data)}
+	}
+	return nil
+}
+
+// AppendInt32 appends the encoding of v to buf.
+func AppendInt32(buf []byte, v int32) []byte {
+	return binary.LittleEndian.AppendUint32(buf, uint32(v))
+}
+
+// AppendInt64 appends the encoding of v to buf.
+func AppendInt64(buf []byte, v int64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, uint64(v))
+}
+
+// AppendFloat32 appends the encoding of v to buf.
+func AppendFloat32(buf []byte, v float32) []byte {
+	return binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
+}
+
+// AppendFloat64 appends the encoding of v to buf.
+func AppendFloat64(buf []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+}
+
+// AppendBool appends the encoding of v to buf.
+func AppendBool(buf []byte, v bool) []byte {
+	if v {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+// AppendString appends the encoding of v to buf.
+func AppendString(buf []byte, v string) []byte {
+	return append(buf, v...)
+}
+
+// DecodeInt32 decodes a value written by AppendInt32.
+func DecodeInt32(data []byte) (int32, error) {
+	if err := checkSize(Int32, data); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(data)), nil
+}
+
+// DecodeInt64 decodes a value written by AppendInt64.
+func DecodeInt64(data []byte) (int64, error) {
+	if err := checkSize(Int64, data); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(data)), nil
+}
+
+// DecodeFloat32 decodes a value written by AppendFloat32.
+func DecodeFloat32(data []byte) (float32, error) {
+	if err := checkSize(Float32, data); err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(data)), nil
+}
+
+// DecodeFloat64 decodes a value written by AppendFloat64.
+func DecodeFloat64(data []byte) (float64, error) {
+	if err := checkSize(Float64, data); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+}
+
+// DecodeBool decodes a value written by AppendBool.
+func DecodeBool(data []byte) (bool, error) {
+	if err := checkSize(Bool, data); err != nil {
+		return false, err
+	}
+	switch data[0] {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, ErrBadBool
+}
+
+// DecodeString decodes a value written by AppendString.
+func DecodeString(data []byte) string {
+	return string(data)
+}
+
+// Version is the version of the encoding described above.
+const Version = 1
+
+// LegacyVersion is the version of data written before the format was
+// versioned: integers as decimal text, bools as "true" or "false", and
+// floats converted to integers, stored as 4 or 8 bytes little-endian.
+const LegacyVersion = 0
+
+// Legacy converts a value of the legacy encoding to the current one.
+// Floats keep only the integer part the legacy encoding stored; negative
+// ones were not stored at all and come out as garbage.
+func Legacy(k Kind, data []byte) ([]byte, error) {
+	switch k {
+	case Int32, Int64:
+		n, err := strconv.ParseInt(string(data), 10, 8*k.Size())
+		if err != nil {
+			return nil, fmt.Errorf("fieldcodec: legacy %s: %w", k, err)
+		}
+		if k == Int32 {
+			return AppendInt32(nil, int32(n)), nil
+		}
+		return AppendInt64(nil, n), nil
+	case Float32:
+		if err := checkSize(k, data); err != nil {
+			return nil, err
+		}
+		return AppendFloat32(nil, float32(binary.LittleEndian.Uint32(data))), nil
+	case Float64:
+		if err := checkSize(k, data); err != nil {
+			return nil, err
+		}
+		return AppendFloat64(nil, float64(binary.LittleEndian.Uint64(data))), nil
+	case Bool:
+		switch string(data) {
+		case "true":
+			return AppendBool(nil, true), nil
+		case "false":
+			return AppendBool(nil, false), nil
+		}
+		return nil, fmt.Errorf("fieldcodec: legacy bool %q", data)
+	case String:
+		return append([]byte(nil), data...), nil
+	}
+	return nil, fmt.Errorf("fieldcodec: unknown kind %v", k)
+}
+
+var versionKey = []byte("format_version")
+
+// ErrLegacyFormat is returned by CheckVersion for data in the legacy
+// encoding.
+var ErrLegacyFormat = errors.New("fieldcodec: data uses the legacy lossy encoding and must be migrated")
+
+// ReadVersion returns the version recorded in meta. Data without one is in
+// the legacy encoding, unless there is no data at all, which is reported
+// as Version.
+func ReadVersion(meta, data *bbolt.Bucket) (uint64, error) {
+	if v := meta.Get(versionKey); v != nil {
+		version, n := binary.Uvarint(v)
+		if n != len(v) {
+			return 0, fmt.Errorf("fieldcodec: bad format version %x", v)
+		}
+		return version, nil
+	}
+	if k, _ := data.Cursor().First(); k != nil {
+		return LegacyVersion, nil
+	}
+	return Version, nil
+}
+
+// SetVersion records version in meta.
+func SetVersion(meta *bbolt.Bucket, version uint64) error {
+	return meta.Put(versionKey, binary.AppendUvarint(nil, version))
+}
+
+// CheckVersion makes sure the values in data use the current encoding,
+// recording it in meta for new data. It returns ErrLegacyFormat for data
+// in the legacy encoding.
+func CheckVersion(meta, data *bbolt.Bucket) error {
+	version, err := ReadVersion(meta, data)
+	if err != nil {
+		return err
+	}
+	switch version {
+	case Version:
+		if meta.Get(versionKey) == nil {
+			return SetVersion(meta, Version)
+		}
+		return nil
+	case LegacyVersion:
+		return ErrLegacyFormat
+	}
+	return fmt.Errorf("fieldcodec: unsupported format version %d", version)
+}
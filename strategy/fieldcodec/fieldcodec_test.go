@@ -0,0 +1,35 @@
+package fieldcodec
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFloatsBitExact(t *testing.T) {
+	for _, bits := range []uint64{0, 1 << 63, math.Float64bits(math.Inf(-1)), 0x7ff8000000000001, math.Float64bits(-0.125)} {
+		got, err := DecodeFloat64(AppendFloat64(nil, math.Float64frombits(bits)))
+		if err != nil || math.Float64bits(got) != bits {
+			t.Errorf("float64 %#x: got %#x, %v", bits, math.Float64bits(got), err)
+		}
+	}
+	for _, bits := range []uint32{0, 1 << 31, math.Float32bits(float32(math.Inf(1))), 0x7fc00001, math.Float32bits(150.625)} {
+		got, err := DecodeFloat32(AppendFloat32(nil, math.Float32frombits(bits)))
+		if err != nil || math.Float32bits(got) != bits {
+			t.Errorf("float32 %#x: got %#x, %v", bits, math.Float32bits(got), err)
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	var sizeErr *SizeError
+	if _, err := DecodeInt64([]byte("42")); !errors.As(err, &sizeErr) {
+		t.Errorf("DecodeInt64(legacy text) = %v, want a SizeError", err)
+	}
+	if _, err := DecodeInt32(AppendInt64(nil, 1)); !errors.As(err, &sizeErr) {
+		t.Errorf("DecodeInt32(8 bytes) = %v, want a SizeError", err)
+	}
+	if _, err := DecodeBool([]byte{2}); !errors.Is(err, ErrBadBool) {
+		t.Errorf("DecodeBool(2) = %v, want ErrBadBool", err)
+	}
+}
@@ -0,0 +1,248 @@
+package strategy
+
+import (
+	"boltdb_benchmarks/strategy/fieldcodec"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// userField describes one UserInfo field: its json name, its type tag
+// (shared with Binary+Names) and how to move it in and out of bytes.
+//
+// appendValue writes the bare value in the fieldcodec encoding of its kind:
+// fixed-width little-endian for numbers, one byte for bools and the raw
+// bytes for strings. decodeValue expects exactly the bytes appendValue
+// produced.
+type userField struct {
+	name        string
+	tag         byte
+	appendValue func(buf []byte, user *UserInfo) []byte
+	decodeValue func(data []byte, user *UserInfo) error
+}
+
+// userFields lists the UserInfo fields in declaration order.
+var userFields = []userField{
+	{"id", tagInt64,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendInt64(buf, u.ID) },
+		func(data []byte, u *UserInfo) (err error) { u.ID, err = fieldcodec.DecodeInt64(data); return }},
+	{"username", tagString,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendString(buf, u.Username) },
+		func(data []byte, u *UserInfo) error { u.Username = fieldcodec.DecodeString(data); return nil }},
+	{"email", tagString,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendString(buf, u.Email) },
+		func(data []byte, u *UserInfo) error { u.Email = fieldcodec.DecodeString(data); return nil }},
+	{"first_name", tagString,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendString(buf, u.FirstName) },
+		func(data []byte, u *UserInfo) error { u.FirstName = fieldcodec.DecodeString(data); return nil }},
+	{"last_name", tagString,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendString(buf, u.LastName) },
+		func(data []byte, u *UserInfo) error { u.LastName = fieldcodec.DecodeString(data); return nil }},
+	{"age", tagInt32,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendInt32(buf, u.Age) },
+		func(data []byte, u *UserInfo) (err error) { u.Age, err = fieldcodec.DecodeInt32(data); return }},
+	{"height", tagFloat32,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendFloat32(buf, u.Height) },
+		func(data []byte, u *UserInfo) (err error) { u.Height, err = fieldcodec.DecodeFloat32(data); return }},
+	{"weight", tagFloat32,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendFloat32(buf, u.Weight) },
+		func(data []byte, u *UserInfo) (err error) { u.Weight, err = fieldcodec.DecodeFloat32(data); return }},
+	{"balance", tagFloat64,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendFloat64(buf, u.Balance) },
+		func(data []byte, u *UserInfo) (err error) { u.Balance, err = fieldcodec.DecodeFloat64(data); return }},
+	{"is_active", tagBool,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendBool(buf, u.IsActive) },
+		func(data []byte, u *UserInfo) (err error) { u.IsActive, err = fieldcodec.DecodeBool(data); return }},
+	{"created_at", tagInt64,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendInt64(buf, u.CreatedAt) },
+		func(data []byte, u *UserInfo) (err error) { u.CreatedAt, err = fieldcodec.DecodeInt64(data); return }},
+	{"updated_at", tagInt64,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendInt64(buf, u.UpdatedAt) },
+		func(data []byte, u *UserInfo) (err error) { u.UpdatedAt, err = fieldcodec.DecodeInt64(data); return }},
+	{"login_count", tagInt32,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendInt32(buf, u.LoginCount) },
+		func(data []byte, u *UserInfo) (err error) { u.LoginCount, err = fieldcodec.DecodeInt32(data); return }},
+	{"score", tagFloat64,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendFloat64(buf, u.Score) },
+		func(data []byte, u *UserInfo) (err error) { u.Score, err = fieldcodec.DecodeFloat64(data); return }},
+	{"description", tagString,
+		func(buf []byte, u *UserInfo) []byte { return fieldcodec.AppendString(buf, u.Description) },
+		func(data []byte, u *UserInfo) error { u.Description = fieldcodec.DecodeString(data); return nil }},
+}
+
+// lookupUserField returns the index of the named field in userFields.
+func lookupUserField(name string) (int, bool) {
+	for i := range userFields {
+		if userFields[i].name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// fixedSize returns the encoded width of a fixed-size tag, or 0 for strings.
+func fixedSize(tag byte) int {
+	switch tag {
+	case tagInt64, tagFloat64:
+		return 8
+	case tagInt32, tagFloat32:
+		return 4
+	case tagBool:
+		return 1
+	}
+	return 0
+}
+
+// appendPackedField writes a field so that it can be followed by others:
+// strings get an int32 length prefix, as in BinaryStrategy.
+func appendPackedField(buf []byte, f *userField, user *UserInfo) []byte {
+	if f.tag != tagString {
+		return f.appendValue(buf, user)
+	}
+	lenAt := len(buf)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = f.appendValue(buf, user)
+	binary.LittleEndian.PutUint32(buf[lenAt:], uint32(len(buf)-lenAt-4))
+	return buf
+}
+
+// decodePackedField reads a field written by appendPackedField and returns
+// the remaining input.
+func decodePackedField(data []byte, f *userField, user *UserInfo) ([]byte, error) {
+	size := fixedSize(f.tag)
+	if f.tag == tagString {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("read %s length: %w", f.name, ErrTruncated)
+		}
+		n := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(n) > uint64(len(data)) {
+			return nil, &LengthError{Field: f.name, Length: int64(n), Remaining: len(data)}
+		}
+		size = int(n)
+	} else if len(data) < size {
+		return nil, fmt.Errorf("read %s: %w", f.name, ErrTruncated)
+	}
+	if err := f.decodeValue(data[:size], user); err != nil {
+		return nil, err
+	}
+	return data[size:], nil
+}
+
+// setUserField assigns value to the named field, with full type-checking.
+// The ID is not updatable: it is also the record's key.
+func setUserField(user *UserInfo, fieldName string, value interface{}) error {
+	var ok bool
+	switch fieldName {
+	case "username":
+		user.Username, ok = value.(string)
+	case "email":
+		user.Email, ok = value.(string)
+	case "first_name":
+		user.FirstName, ok = value.(string)
+	case "last_name":
+		user.LastName, ok = value.(string)
+	case "age":
+		user.Age, ok = value.(int32)
+	case "height":
+		user.Height, ok = value.(float32)
+	case "weight":
+		user.Weight, ok = value.(float32)
+	case "balance":
+		user.Balance, ok = value.(float64)
+	case "is_active":
+		user.IsActive, ok = value.(bool)
+	case "created_at":
+		user.CreatedAt, ok = value.(int64)
+	case "updated_at":
+		user.UpdatedAt, ok = value.(int64)
+	case "login_count":
+		user.LoginCount, ok = value.(int32)
+	case "score":
+		user.Score, ok = value.(float64)
+	case "description":
+		user.Description, ok = value.(string)
+	default:
+		return fmt.Errorf("field %q is not updatable", fieldName)
+	}
+	if !ok {
+		return fmt.Errorf("%s: unexpected value type %T", fieldName, value)
+	}
+	return nil
+}
+
+// userFieldNumber returns the value of a summable field as float64.
+func userFieldNumber(user *UserInfo, fieldName string) (float64, error) {
+	switch fieldName {
+	case "balance":
+		return user.Balance, nil
+	case "score":
+		return user.Score, nil
+	case "login_count":
+		return float64(user.LoginCount), nil
+	}
+	return 0, fmt.Errorf("cannot sum field %q", fieldName)
+}
+
+// fieldKind returns the fieldcodec kind of a type tag.
+func fieldKind(tag byte) fieldcodec.Kind {
+	switch tag {
+	case tagInt32:
+		return fieldcodec.Int32
+	case tagInt64:
+		return fieldcodec.Int64
+	case tagFloat32:
+		return fieldcodec.Float32
+	case tagFloat64:
+		return fieldcodec.Float64
+	case tagBool:
+		return fieldcodec.Bool
+	}
+	return fieldcodec.String
+}
+
+// decodeNamedField decodes a value written by the named field's appendValue
+// into user. Unknown fields are ignored.
+func decodeNamedField(user *UserInfo, name string, data []byte) error {
+	i, ok := lookupUserField(name)
+	if !ok {
+		return nil
+	}
+	if err := userFields[i].decodeValue(data, user); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// migrateLegacyFields rewrites the field values in b from the legacy
+// encoding to the fieldcodec one, nameOf giving the field each key holds.
+func migrateLegacyFields(b *bbolt.Bucket, nameOf func(key []byte) string) error {
+	// Collect first: bbolt cursors are invalidated by writes.
+	type kv struct{ k, v []byte }
+	var migrated []kv
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil // a nested bucket
+		}
+		name := nameOf(k)
+		i, ok := lookupUserField(name)
+		if !ok {
+			return fmt.Errorf("migrate: unknown field %q", name)
+		}
+		nv, err := fieldcodec.Legacy(fieldKind(userFields[i].tag), v)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %w", name, err)
+		}
+		migrated = append(migrated, kv{append([]byte(nil), k...), nv})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range migrated {
+		if err := b.Put(m.k, m.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
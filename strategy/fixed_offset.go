@@ -0,0 +1,258 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+)
+
+// 13. Fixed-offset strategy
+//
+// Numeric fields sit at fixed offsets in a header, followed by a table of
+// string end offsets and the string bytes:
+//
+//	header (57 bytes) | 5 × uint32 string end offset | strings
+//
+// Updating a numeric field patches its bytes in a copy of the stored value
+// instead of decoding and re-encoding the whole record.
+type FixedOffsetStrategy struct{}
+
+// fixedOffsets maps the numeric fields to their offset in the header.
+var fixedOffsets = map[string]int{
+	"id":          0,
+	"age":         8,
+	"height":      12,
+	"weight":      16,
+	"balance":     20,
+	"created_at":  28,
+	"updated_at":  36,
+	"login_count": 44,
+	"score":       48,
+	"is_active":   56,
+}
+
+const (
+	fixedHeaderSize  = 57
+	fixedStringCount = 5
+	fixedStringsAt   = fixedHeaderSize + 4*fixedStringCount
+)
+
+func (s *FixedOffsetStrategy) Name() string { return "FixedOffset" }
+
+func (s *FixedOffsetStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_fixed"))
+		return err
+	})
+}
+
+func (s *FixedOffsetStrategy) encodeFixed(user *UserInfo) []byte {
+	strs := [fixedStringCount]string{user.Username, user.Email, user.FirstName, user.LastName, user.Description}
+	size := fixedStringsAt
+	for _, str := range strs {
+		size += len(str)
+	}
+	buf := make([]byte, fixedStringsAt, size)
+
+	le := binary.LittleEndian
+	le.PutUint64(buf[0:], uint64(user.ID))
+	le.PutUint32(buf[8:], uint32(user.Age))
+	le.PutUint32(buf[12:], math.Float32bits(user.Height))
+	le.PutUint32(buf[16:], math.Float32bits(user.Weight))
+	le.PutUint64(buf[20:], math.Float64bits(user.Balance))
+	le.PutUint64(buf[28:], uint64(user.CreatedAt))
+	le.PutUint64(buf[36:], uint64(user.UpdatedAt))
+	le.PutUint32(buf[44:], uint32(user.LoginCount))
+	le.PutUint64(buf[48:], math.Float64bits(user.Score))
+	if user.IsActive {
+		buf[56] = 1
+	}
+
+	end := 0
+	for i, str := range strs {
+		end += len(str)
+		le.PutUint32(buf[fixedHeaderSize+4*i:], uint32(end))
+		buf = append(buf, str...)
+	}
+	return buf
+}
+
+func (s *FixedOffsetStrategy) decodeFixed(data []byte) (*UserInfo, error) {
+	if len(data) < fixedStringsAt {
+		return nil, fmt.Errorf("fixed: record of %d bytes is shorter than its header", len(data))
+	}
+	if data[56] > 1 {
+		return nil, fmt.Errorf("fixed: read is_active: %w", ErrBadBool)
+	}
+	le := binary.LittleEndian
+	user := &UserInfo{
+		ID:         int64(le.Uint64(data[0:])),
+		Age:        int32(le.Uint32(data[8:])),
+		Height:     math.Float32frombits(le.Uint32(data[12:])),
+		Weight:     math.Float32frombits(le.Uint32(data[16:])),
+		Balance:    math.Float64frombits(le.Uint64(data[20:])),
+		CreatedAt:  int64(le.Uint64(data[28:])),
+		UpdatedAt:  int64(le.Uint64(data[36:])),
+		LoginCount: int32(le.Uint32(data[44:])),
+		Score:      math.Float64frombits(le.Uint64(data[48:])),
+		IsActive:   data[56] != 0,
+	}
+
+	strs := data[fixedStringsAt:]
+	var out [fixedStringCount]string
+	start := 0
+	for i := range out {
+		end := int(le.Uint32(data[fixedHeaderSize+4*i:]))
+		if end < start || end > len(strs) {
+			return nil, fmt.Errorf("fixed: bad string offset %d", end)
+		}
+		out[i] = string(strs[start:end])
+		start = end
+	}
+	if start != len(strs) {
+		return nil, fmt.Errorf("fixed: %d bytes after the last string: %w", len(strs)-start, ErrTrailingData)
+	}
+	user.Username, user.Email, user.FirstName, user.LastName, user.Description =
+		out[0], out[1], out[2], out[3], out[4]
+	return user, nil
+}
+
+func (s *FixedOffsetStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_fixed"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, s.encodeFixed(user))
+	})
+}
+
+func (s *FixedOffsetStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_fixed"))
+		for _, user := range users {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, s.encodeFixed(user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *FixedOffsetStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_fixed"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeFixed(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *FixedOffsetStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_fixed")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeFixed(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *FixedOffsetStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_fixed"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		if len(data) < fixedStringsAt {
+			return fmt.Errorf("fixed: record of %d bytes is shorter than its header", len(data))
+		}
+
+		offset, ok := fixedOffsets[fieldName]
+		if !ok {
+			// Strings change the record size: fall back to a full rewrite.
+			user, err := s.decodeFixed(data)
+			if err != nil {
+				return err
+			}
+			if err := setUserField(user, fieldName, value); err != nil {
+				return err
+			}
+			return b.Put(key, s.encodeFixed(user))
+		}
+
+		// Encode just the new value and patch it into a copy of the record;
+		// the stored slice belongs to bbolt and must not be modified.
+		var patch UserInfo
+		if err := setUserField(&patch, fieldName, value); err != nil {
+			return err
+		}
+		i, _ := lookupUserField(fieldName)
+		newData := append([]byte(nil), data...)
+		copy(newData[offset:], userFields[i].appendValue(nil, &patch))
+		return b.Put(key, newData)
+	})
+}
+
+func (s *FixedOffsetStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	offset, ok := fixedOffsets[fieldName]
+	if !ok {
+		return 0, fmt.Errorf("cannot sum field %q", fieldName)
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_fixed")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			if len(v) < fixedStringsAt {
+				return fmt.Errorf("fixed: record of %d bytes is shorter than its header", len(v))
+			}
+			switch fieldName {
+			case "balance", "score":
+				sum += math.Float64frombits(binary.LittleEndian.Uint64(v[offset:]))
+			case "login_count":
+				sum += float64(int32(binary.LittleEndian.Uint32(v[offset:])))
+			default:
+				return fmt.Errorf("cannot sum field %q", fieldName)
+			}
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *FixedOffsetStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeFixed(user), nil
+}
+
+func (s *FixedOffsetStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeFixed(data)
+}
+
+func (s *FixedOffsetStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_fixed"), corrupt)
+}
@@ -0,0 +1,341 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+)
+
+// 14. Zero-copy field view strategy
+//
+// A FlatBuffers-style layout: a vtable of slot offsets, one per field in
+// userFields order, followed by the slots and the string bytes:
+//
+//	n uint16 | n × slot offset uint16 | slots | strings
+//
+// Numeric slots hold the little-endian value; string slots hold a uint32
+// offset and a uint32 length. A zero slot offset, or a field beyond n,
+// reads as the zero value, so fields can be added without a rewrite.
+// ReadFieldSum reads single fields with a FieldView straight from the
+// bytes bbolt returns, without decoding or allocating.
+type FlatViewStrategy struct{}
+
+func (s *FlatViewStrategy) Name() string { return "FlatView" }
+
+func (s *FlatViewStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_flat"))
+		return err
+	})
+}
+
+// flatSlotSize returns the size of a field's slot.
+func flatSlotSize(tag byte) int {
+	if tag == tagString {
+		return 8
+	}
+	return fixedSize(tag)
+}
+
+func (s *FlatViewStrategy) encodeFlat(user *UserInfo) []byte {
+	n := len(userFields)
+	slotsAt := 2 + 2*n
+	size := slotsAt
+	for i := range userFields {
+		size += flatSlotSize(userFields[i].tag)
+	}
+	stringsAt := size
+
+	buf := make([]byte, stringsAt, stringsAt+64)
+	binary.LittleEndian.PutUint16(buf, uint16(n))
+	off := slotsAt
+	for i := range userFields {
+		f := &userFields[i]
+		binary.LittleEndian.PutUint16(buf[2+2*i:], uint16(off))
+		if f.tag == tagString {
+			start := len(buf)
+			buf = f.appendValue(buf, user)
+			binary.LittleEndian.PutUint32(buf[off:], uint32(start))
+			binary.LittleEndian.PutUint32(buf[off+4:], uint32(len(buf)-start))
+		} else {
+			copy(buf[off:], f.appendValue(nil, user))
+		}
+		off += flatSlotSize(f.tag)
+	}
+	return buf
+}
+
+// FieldView reads single fields of a FlatView record in place. String
+// accessors return slices of the record, so a view is only valid as long as
+// its bytes are; for bbolt values that is the enclosing transaction.
+type FieldView struct {
+	data []byte
+}
+
+// NewFieldView checks that every slot of data lies within it, so the
+// accessors need no further bounds checks.
+func NewFieldView(data []byte) (FieldView, error) {
+	if len(data) < 2 {
+		return FieldView{}, fmt.Errorf("flat: truncated vtable")
+	}
+	n := int(binary.LittleEndian.Uint16(data))
+	if len(data) < 2+2*n {
+		return FieldView{}, fmt.Errorf("flat: truncated vtable of %d entries", n)
+	}
+	for i := 0; i < n && i < len(userFields); i++ {
+		off := int(binary.LittleEndian.Uint16(data[2+2*i:]))
+		if off == 0 {
+			continue
+		}
+		tag := userFields[i].tag
+		if off+flatSlotSize(tag) > len(data) {
+			return FieldView{}, fmt.Errorf("flat: %s slot out of range", userFields[i].name)
+		}
+		if tag == tagString {
+			start := uint64(binary.LittleEndian.Uint32(data[off:]))
+			length := uint64(binary.LittleEndian.Uint32(data[off+4:]))
+			if start+length > uint64(len(data)) {
+				return FieldView{}, fmt.Errorf("flat: %s out of range", userFields[i].name)
+			}
+		}
+	}
+	return FieldView{data: data}, nil
+}
+
+// slot returns the offset of field i, or 0 if the record does not have it.
+func (v FieldView) slot(i int) int {
+	if 2+2*i+2 > len(v.data) || i >= int(binary.LittleEndian.Uint16(v.data)) {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint16(v.data[2+2*i:]))
+}
+
+func (v FieldView) u64(i int) uint64 {
+	if off := v.slot(i); off != 0 {
+		return binary.LittleEndian.Uint64(v.data[off:])
+	}
+	return 0
+}
+
+func (v FieldView) u32(i int) uint32 {
+	if off := v.slot(i); off != 0 {
+		return binary.LittleEndian.Uint32(v.data[off:])
+	}
+	return 0
+}
+
+func (v FieldView) bytes(i int) []byte {
+	off := v.slot(i)
+	if off == 0 {
+		return nil
+	}
+	start := binary.LittleEndian.Uint32(v.data[off:])
+	length := binary.LittleEndian.Uint32(v.data[off+4:])
+	return v.data[start : start+length]
+}
+
+// Field indexes into userFields.
+const (
+	flatID = iota
+	flatUsername
+	flatEmail
+	flatFirstName
+	flatLastName
+	flatAge
+	flatHeight
+	flatWeight
+	flatBalance
+	flatIsActive
+	flatCreatedAt
+	flatUpdatedAt
+	flatLoginCount
+	flatScore
+	flatDescription
+)
+
+func (v FieldView) ID() int64           { return int64(v.u64(flatID)) }
+func (v FieldView) Username() []byte    { return v.bytes(flatUsername) }
+func (v FieldView) Email() []byte       { return v.bytes(flatEmail) }
+func (v FieldView) FirstName() []byte   { return v.bytes(flatFirstName) }
+func (v FieldView) LastName() []byte    { return v.bytes(flatLastName) }
+func (v FieldView) Age() int32          { return int32(v.u32(flatAge)) }
+func (v FieldView) Height() float32     { return math.Float32frombits(v.u32(flatHeight)) }
+func (v FieldView) Weight() float32     { return math.Float32frombits(v.u32(flatWeight)) }
+func (v FieldView) Balance() float64    { return math.Float64frombits(v.u64(flatBalance)) }
+func (v FieldView) CreatedAt() int64    { return int64(v.u64(flatCreatedAt)) }
+func (v FieldView) UpdatedAt() int64    { return int64(v.u64(flatUpdatedAt)) }
+func (v FieldView) LoginCount() int32   { return int32(v.u32(flatLoginCount)) }
+func (v FieldView) Score() float64      { return math.Float64frombits(v.u64(flatScore)) }
+func (v FieldView) Description() []byte { return v.bytes(flatDescription) }
+func (v FieldView) IsActive() bool {
+	off := v.slot(flatIsActive)
+	return off != 0 && v.data[off] != 0
+}
+
+// User copies the whole record out of the view.
+func (v FieldView) User() *UserInfo {
+	return &UserInfo{
+		ID:          v.ID(),
+		Username:    string(v.Username()),
+		Email:       string(v.Email()),
+		FirstName:   string(v.FirstName()),
+		LastName:    string(v.LastName()),
+		Age:         v.Age(),
+		Height:      v.Height(),
+		Weight:      v.Weight(),
+		Balance:     v.Balance(),
+		IsActive:    v.IsActive(),
+		CreatedAt:   v.CreatedAt(),
+		UpdatedAt:   v.UpdatedAt(),
+		LoginCount:  v.LoginCount(),
+		Score:       v.Score(),
+		Description: string(v.Description()),
+	}
+}
+
+func (s *FlatViewStrategy) decodeFlat(data []byte) (*UserInfo, error) {
+	view, err := NewFieldView(data)
+	if err != nil {
+		return nil, err
+	}
+	return view.User(), nil
+}
+
+func (s *FlatViewStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_flat"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, s.encodeFlat(user))
+	})
+}
+
+func (s *FlatViewStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_flat"))
+		for _, user := range users {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, s.encodeFlat(user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *FlatViewStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_flat"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeFlat(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *FlatViewStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_flat")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeFlat(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *FlatViewStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_flat"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		view, err := NewFieldView(data)
+		if err != nil {
+			return err
+		}
+
+		// Numeric fields present in the record are patched in a copy.
+		i, ok := lookupUserField(fieldName)
+		if ok && userFields[i].tag != tagString {
+			if off := view.slot(i); off != 0 {
+				var patch UserInfo
+				if err := setUserField(&patch, fieldName, value); err != nil {
+					return err
+				}
+				newData := append([]byte(nil), data...)
+				copy(newData[off:], userFields[i].appendValue(nil, &patch))
+				return b.Put(key, newData)
+			}
+		}
+
+		user := view.User()
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		return b.Put(key, s.encodeFlat(user))
+	})
+}
+
+func (s *FlatViewStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	var field func(FieldView) float64
+	switch fieldName {
+	case "balance":
+		field = FieldView.Balance
+	case "score":
+		field = FieldView.Score
+	case "login_count":
+		field = func(v FieldView) float64 { return float64(v.LoginCount()) }
+	default:
+		return 0, fmt.Errorf("cannot sum field %q", fieldName)
+	}
+
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_flat")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			view, err := NewFieldView(v)
+			if err != nil {
+				return err
+			}
+			sum += field(view)
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *FlatViewStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeFlat(user), nil
+}
+
+func (s *FlatViewStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeFlat(data)
+}
+
+func (s *FlatViewStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_flat"), corrupt)
+}
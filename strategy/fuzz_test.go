@@ -0,0 +1,295 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"boltdb_benchmarks/strategy/fieldcodec"
+	"go.etcd.io/bbolt"
+)
+
+// fuzzSeeds are the records whose encodings seed the fuzz corpora.
+func fuzzSeeds() []*UserInfo {
+	return []*UserInfo{
+		{},
+		{
+			ID: 42, Username: "user_42", Email: "user42@example.com", FirstName: "First", LastName: "Lást ✓",
+			Age: 30, Height: 180.25, Weight: 75.5, Balance: -1234.5678, IsActive: true,
+			CreatedAt: 1_700_000_000, UpdatedAt: 1_700_000_042, LoginCount: -7, Score: 0.1,
+			Description: "a comma, \"quotes\" and a\nnewline",
+		},
+		{ID: -1, Height: float32(math.Inf(1)), Balance: math.NaN(), Score: math.Copysign(0, -1)},
+	}
+}
+
+// fuzzCodec checks that decode never panics, and that whatever it accepts
+// survives another encode and decode unchanged. Comparing encodings rather
+// than records keeps NaN fields comparable.
+func fuzzCodec(f *testing.F, encode func(*UserInfo) ([]byte, error), decode func([]byte) (*UserInfo, error), corrupt ...[]byte) {
+	for _, u := range fuzzSeeds() {
+		data, err := encode(u)
+		if err != nil {
+			continue // JSON has no NaN or infinities
+		}
+		f.Add(data)
+	}
+	for _, data := range corrupt {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		user, err := decode(data)
+		if err != nil {
+			return
+		}
+		enc, err := encode(user)
+		if err != nil {
+			t.Fatalf("encode of a decoded record: %v", err)
+		}
+		again, err := decode(enc)
+		if err != nil {
+			t.Fatalf("decode of a re-encoded record: %v", err)
+		}
+		enc2, err := encode(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(enc, enc2) {
+			t.Fatalf("re-encoding changed the record:\n %x\n %x", enc, enc2)
+		}
+	})
+}
+
+func FuzzDecodeBinary(f *testing.F) {
+	s := &BinaryStrategy{}
+	fuzzCodec(f, s.encodeValue, s.decodeValue,
+		binary.LittleEndian.AppendUint32(make([]byte, 8), 0xffffffff), // negative length
+		binary.LittleEndian.AppendUint32(make([]byte, 8), 0x7fffffff), // oversized length
+	)
+}
+
+func FuzzDecodeBinaryWithNames(f *testing.F) {
+	s := &BinaryWithNamesStrategy{}
+	fuzzCodec(f, s.encodeValue, s.decodeValue,
+		binary.LittleEndian.AppendUint32(nil, 0x7fffffff), // oversized field count
+		append(binary.LittleEndian.AppendUint32([]byte{1, 0, 0, 0, 2, 0, 0, 0}, 0), "id\x09"...),
+	)
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	s := &JSONStrategy{}
+	fuzzCodec(f, s.encodeValue, s.decodeValue, []byte(`{"id":1e400}`), []byte(`{"username":"\ud800"}`))
+}
+
+func FuzzDecodeGOB(f *testing.F) {
+	s := &GOBStrategy{}
+	fuzzCodec(f, s.encodeValue, s.decodeValue)
+}
+
+// FuzzDecodeGOBPreamble decodes values against a stored preamble, and
+// checks that a rejected value does not break the decoding of good ones.
+func FuzzDecodeGOBPreamble(f *testing.F) {
+	db, err := bbolt.Open(filepath.Join(f.TempDir(), "fuzz.db"), 0600, nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer db.Close()
+	s := &GOBPreambleStrategy{}
+	if err := s.Setup(db); err != nil {
+		f.Fatal(err)
+	}
+	var good []byte
+	err = db.View(func(tx *bbolt.Tx) error {
+		meta := tx.Bucket([]byte("users_gob_meta"))
+		for _, u := range fuzzSeeds() {
+			data, err := s.encode(meta, u)
+			if err != nil {
+				return err
+			}
+			f.Add(data)
+			good = data
+		}
+		return nil
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		err := db.View(func(tx *bbolt.Tx) error {
+			meta := tx.Bucket([]byte("users_gob_meta"))
+			s.decode(meta, data)
+			_, err := s.decode(meta, good)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("good value rejected after decoding %x: %v", data, err)
+		}
+	})
+}
+
+// FuzzDecodeNamedField covers the field values of MultiKV and
+// NestedBucket: every value a field accepts must be exactly what its
+// encoder writes.
+func FuzzDecodeNamedField(f *testing.F) {
+	for _, u := range fuzzSeeds() {
+		for i := range userFields {
+			f.Add(userFields[i].name, userFields[i].appendValue(nil, u))
+		}
+	}
+	f.Add("is_active", []byte{2})
+	f.Add("balance", []byte("1234"))
+	f.Fuzz(func(t *testing.T, name string, data []byte) {
+		var user UserInfo
+		if err := decodeNamedField(&user, name, data); err != nil {
+			return
+		}
+		i, ok := lookupUserField(name)
+		if !ok {
+			return
+		}
+		if enc := userFields[i].appendValue(nil, &user); !bytes.Equal(enc, data) {
+			t.Fatalf("%s: decoded %x, encodes as %x", name, data, enc)
+		}
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var lengthErr *LengthError
+	var tagErr *TagError
+	var sizeErr *fieldcodec.SizeError
+	record := (&BinaryStrategy{}).encodeBinary(&UserInfo{})
+	badBool := append([]byte(nil), record...)
+	badBool[len(badBool)-29] = 2 // is_active, before created_at, updated_at, login_count and score
+	names, err := (&BinaryWithNamesStrategy{}).encodeBinaryWithNames(&UserInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := (&FixedOffsetStrategy{}).encodeFixed(&UserInfo{Username: "u"})
+	fixedBool := append([]byte(nil), fixed...)
+	fixedBool[56] = 2
+	codec, err := CodecFor(typeOf[UserInfo]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reflectBinary := func(data []byte) (*UserInfo, error) {
+		var u UserInfo
+		return &u, codec.DecodeBinary(data, &u)
+	}
+	reflectStored := func(name string) func([]byte) (*UserInfo, error) {
+		return func(data []byte) (*UserInfo, error) {
+			var u UserInfo
+			return &u, codec.SetStored(&u, name, data)
+		}
+	}
+	tests := []struct {
+		name   string
+		decode func([]byte) (*UserInfo, error)
+		data   []byte
+		check  func(error) bool
+	}{
+		{"binary empty", (&BinaryStrategy{}).decodeValue, nil,
+			func(err error) bool { return errors.Is(err, ErrTruncated) }},
+		{"binary negative length", (&BinaryStrategy{}).decodeValue,
+			binary.LittleEndian.AppendUint32(make([]byte, 8), 0xffffffff),
+			func(err error) bool { return errors.As(err, &lengthErr) }},
+		{"binary oversized length", (&BinaryStrategy{}).decodeValue,
+			binary.LittleEndian.AppendUint32(make([]byte, 8), 0x7fffffff),
+			func(err error) bool { return errors.As(err, &lengthErr) }},
+		{"binary bad bool", (&BinaryStrategy{}).decodeValue, badBool,
+			func(err error) bool { return errors.Is(err, ErrBadBool) }},
+		{"binary trailing data", (&BinaryStrategy{}).decodeValue, append(record, 0),
+			func(err error) bool { return errors.Is(err, ErrTrailingData) }},
+		{"names trailing data", (&BinaryWithNamesStrategy{}).decodeValue, append(names, 0),
+			func(err error) bool { return errors.Is(err, ErrTrailingData) }},
+		{"fixed bad bool", (&FixedOffsetStrategy{}).decodeFixed, fixedBool,
+			func(err error) bool { return errors.Is(err, ErrBadBool) }},
+		{"fixed trailing data", (&FixedOffsetStrategy{}).decodeFixed, append(fixed, 0),
+			func(err error) bool { return errors.Is(err, ErrTrailingData) }},
+		{"gen bad bool", (&GeneratedBinaryStrategy{}).decodeValue, badBool,
+			func(err error) bool { return errors.Is(err, ErrBadBool) }},
+		{"gen trailing data", (&GeneratedBinaryStrategy{}).decodeValue, append(record, 0),
+			func(err error) bool { return errors.Is(err, ErrTrailingData) }},
+		{"gen truncated", (&GeneratedBinaryStrategy{}).decodeValue, record[:len(record)-1],
+			func(err error) bool { return errors.Is(err, ErrTruncated) }},
+		{"gen oversized length", (&GeneratedBinaryStrategy{}).decodeValue,
+			binary.LittleEndian.AppendUint32(make([]byte, 8), 0x7fffffff),
+			func(err error) bool { return errors.As(err, &lengthErr) }},
+		{"reflect binary bad bool", reflectBinary, badBool,
+			func(err error) bool { return errors.Is(err, ErrBadBool) }},
+		{"reflect stored bad bool", reflectStored("is_active"), []byte{2},
+			func(err error) bool { return errors.Is(err, fieldcodec.ErrBadBool) }},
+		{"reflect stored text bool", reflectStored("is_active"), []byte("true"),
+			func(err error) bool { return errors.As(err, &sizeErr) }},
+		{"reflect stored text int", reflectStored("age"), []byte("-31"),
+			func(err error) bool { return errors.As(err, &sizeErr) }},
+		{"avro bad boolean", func(data []byte) (*UserInfo, error) {
+			_, _, err := readAvroValue(data, "boolean")
+			return nil, err
+		}, []byte{2}, func(err error) bool { return errors.Is(err, ErrBadBool) }},
+		{"names oversized count", (&BinaryWithNamesStrategy{}).decodeValue,
+			binary.LittleEndian.AppendUint32(nil, 0x7fffffff),
+			func(err error) bool { return errors.As(err, &lengthErr) }},
+		{"names bad tag", (&BinaryWithNamesStrategy{}).decodeValue,
+			append([]byte{1, 0, 0, 0, 2, 0, 0, 0}, "id\x09"...),
+			func(err error) bool { return errors.As(err, &tagErr) && tagErr.Tag == 9 }},
+		{"names wrong tag for field", (&BinaryWithNamesStrategy{}).decodeValue,
+			append([]byte{1, 0, 0, 0, 2, 0, 0, 0}, "id\x06\x01"...),
+			func(err error) bool { return errors.As(err, &tagErr) && tagErr.Field == "id" }},
+		{"names truncated", (&BinaryWithNamesStrategy{}).decodeValue,
+			append([]byte{1, 0, 0, 0, 2, 0, 0, 0}, "id\x01\x00\x00"...),
+			func(err error) bool { return errors.Is(err, ErrTruncated) }},
+	}
+	for _, tt := range tests {
+		user, err := tt.decode(tt.data)
+		if err == nil {
+			t.Errorf("%s: decoded %+v, want an error", tt.name, user)
+		} else if !tt.check(err) {
+			t.Errorf("%s: wrong error type: %v", tt.name, err)
+		}
+	}
+}
+
+func TestProtoSkipsGroups(t *testing.T) {
+	s := &ProtoWireStrategy{}
+	want := &UserInfo{ID: 7, Username: "user_7"}
+	groups := []byte{
+		0xa3, 0x01, // start group 20
+		0xab, 0x01, 0x08, 0x05, 0xac, 0x01, // group 21 holding field 1
+		0xa4, 0x01, // end group 20
+		0x13, 0x08, 0x09, 0x14, // group 2, a known field number
+	}
+	got, err := s.decodeValue(append(groups, s.encodeProto(want)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Fatalf("decoded %+v, want %+v", got, want)
+	}
+	for _, data := range [][]byte{
+		{0xa3, 0x01, 0x08, 0x05},       // unterminated
+		{0xa3, 0x01, 0xac, 0x01},       // ended by another field
+		{0xa4, 0x01, 0x08, 0x05},       // end without start
+		{0xa3, 0x01, 0x0a, 0x05, 0xa4}, // bad field inside
+	} {
+		if user, err := s.decodeValue(data); err == nil {
+			t.Errorf("%x: decoded %+v, want an error", data, user)
+		}
+	}
+
+	// Groups of field 1 nested n deep.
+	nested := func(n int) []byte {
+		return append(bytes.Repeat([]byte{0x0b}, n), bytes.Repeat([]byte{0x0c}, n)...)
+	}
+	if _, err := s.decodeValue(nested(protoMaxDepth)); err != nil {
+		t.Errorf("groups nested %d deep: %v", protoMaxDepth, err)
+	}
+	for _, data := range [][]byte{nested(protoMaxDepth + 1), bytes.Repeat([]byte{0x0b}, 20<<20)} {
+		if _, err := s.decodeValue(data); err == nil || !strings.Contains(err.Error(), "deeper") {
+			t.Errorf("%d bytes of nested groups: %v, want a depth error", len(data), err)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package strategy
+
+import (
+	"boltdb_benchmarks/strategy/fieldcodec"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"reflect"
+)
+
+// Generic stores
+//
+// Typed repositories over bbolt for any struct type T that StructCodec
+// supports, in the layouts of the UserInfo strategies. Records are keyed by
+// the 8-byte big-endian ID that an accessor returns; a nil accessor uses
+// T's ID field. Every store, JSON and GOB included, takes the ID field and
+// the field names in UpdateField and ReadFieldSum from T's StructCodec, and
+// update values must have the field's exact Go type.
+
+func typeOf[T any]() reflect.Type { return reflect.TypeOf((*T)(nil)).Elem() }
+
+// idAccessor returns id, or if it is nil an accessor for the ID field of
+// codec's type.
+func idAccessor[T any](codec *StructCodec, id func(*T) int64) (func(*T) int64, error) {
+	if id != nil {
+		return id, nil
+	}
+	if !codec.HasID() {
+		return nil, fmt.Errorf("%s has no id field and no ID accessor was given", typeOf[T]())
+	}
+	return func(v *T) int64 { return codec.ID(v) }, nil
+}
+
+// setupFieldBucket creates a bucket of fieldcodec values and its meta
+// bucket, named with a "_meta" suffix, and checks the encoding version as
+// MultiKVStrategy does.
+func setupFieldBucket(tx *bbolt.Tx, bucket []byte) error {
+	b, err := tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return err
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucket(bucket))
+	if err != nil {
+		return err
+	}
+	return fieldcodec.CheckVersion(meta, b)
+}
+
+func metaBucket(bucket []byte) []byte { return []byte(string(bucket) + "_meta") }
+
+func idKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// ValueStore stores each record of type T as a single value keyed by ID.
+type ValueStore[T any] struct {
+	format string
+	bucket []byte
+	id     func(*T) int64
+	codec  *StructCodec
+	encode func(*T) ([]byte, error)
+	decode func([]byte, *T) error
+}
+
+func newValueStore[T any](format, bucket string, id func(*T) int64,
+	encode func(*T) ([]byte, error), decode func([]byte, *T) error) (*ValueStore[T], error) {
+	codec, err := CodecFor(typeOf[T]())
+	if err != nil {
+		return nil, err
+	}
+	if id, err = idAccessor(codec, id); err != nil {
+		return nil, err
+	}
+	return &ValueStore[T]{format: format, bucket: []byte(bucket), id: id, codec: codec, encode: encode, decode: decode}, nil
+}
+
+// NewJSONStore returns a store that encodes records with encoding/json.
+func NewJSONStore[T any](bucket string, id func(*T) int64) (*ValueStore[T], error) {
+	return newValueStore("JSON", bucket, id,
+		func(v *T) ([]byte, error) { return json.Marshal(v) },
+		func(data []byte, v *T) error { return json.Unmarshal(data, v) })
+}
+
+// NewGOBStore returns a store that encodes records with encoding/gob.
+func NewGOBStore[T any](bucket string, id func(*T) int64) (*ValueStore[T], error) {
+	return newValueStore("GOB", bucket, id,
+		func(v *T) ([]byte, error) {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+		func(data []byte, v *T) error { return gob.NewDecoder(bytes.NewReader(data)).Decode(v) })
+}
+
+// NewBinaryStore returns a store that uses the StructCodec Binary layout.
+func NewBinaryStore[T any](bucket string, id func(*T) int64) (*ValueStore[T], error) {
+	codec, err := CodecFor(typeOf[T]())
+	if err != nil {
+		return nil, err
+	}
+	return newValueStore("Binary", bucket, id,
+		func(v *T) ([]byte, error) { return codec.AppendBinary(nil, v), nil },
+		func(data []byte, v *T) error { return codec.DecodeBinary(data, v) })
+}
+
+// NewBinaryNamesStore returns a store that uses the StructCodec
+// Binary+Names layout.
+func NewBinaryNamesStore[T any](bucket string, id func(*T) int64) (*ValueStore[T], error) {
+	codec, err := CodecFor(typeOf[T]())
+	if err != nil {
+		return nil, err
+	}
+	return newValueStore("Binary+Names", bucket, id,
+		func(v *T) ([]byte, error) { return codec.AppendBinaryNames(nil, v), nil },
+		func(data []byte, v *T) error { return codec.DecodeBinaryNames(data, v) })
+}
+
+func (s *ValueStore[T]) Name() string { return s.format + "[" + typeOf[T]().Name() + "]" }
+
+func (s *ValueStore[T]) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	})
+}
+
+func (s *ValueStore[T]) encodeValue(v *T) ([]byte, error) { return s.encode(v) }
+
+func (s *ValueStore[T]) decodeValue(data []byte) (*T, error) {
+	v := new(T)
+	if err := s.decode(data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *ValueStore[T]) Write(db *bbolt.DB, v *T) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		data, err := s.encode(v)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(s.bucket).Put(idKey(s.id(v)), data)
+	})
+}
+
+func (s *ValueStore[T]) WriteMany(db *bbolt.DB, vs []*T) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, v := range vs {
+			data, err := s.encode(v)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(idKey(s.id(v)), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ValueStore[T]) Read(db *bbolt.DB, id int64) (*T, error) {
+	var v *T
+	err := db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(s.bucket).Get(idKey(id))
+		if data == nil {
+			return fmt.Errorf("record %d not found", id)
+		}
+		var err error
+		v, err = s.decodeValue(data)
+		return err
+	})
+	return v, err
+}
+
+func (s *ValueStore[T]) ReadMany(db *bbolt.DB, startId int64, count int) ([]*T, error) {
+	var vs []*T
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, data := c.Seek(idKey(startId)); k != nil && len(vs) < count; k, data = c.Next() {
+			v, err := s.decodeValue(data)
+			if err != nil {
+				return err
+			}
+			vs = append(vs, v)
+		}
+		return nil
+	})
+	return vs, err
+}
+
+func (s *ValueStore[T]) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		key := idKey(id)
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("record %d not found", id)
+		}
+		v, err := s.decodeValue(data)
+		if err != nil {
+			return err
+		}
+		if err := s.codec.SetField(v, fieldName, value); err != nil {
+			return err
+		}
+		newData, err := s.encode(v)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *ValueStore[T]) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		processed := 0
+		for k, data := c.First(); k != nil && processed < count; k, data = c.Next() {
+			v, err := s.decodeValue(data)
+			if err != nil {
+				return err
+			}
+			n, err := s.codec.FieldNumber(v, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+// MultiKVStore stores each field of a record of type T under its own key,
+// the 8-byte ID followed by the field name. The encoding version is kept in
+// the bucket named with a "_meta" suffix; Setup rejects other versions.
+type MultiKVStore[T any] struct {
+	bucket []byte
+	id     func(*T) int64
+	codec  *StructCodec
+}
+
+// NewMultiKVStore returns a store with the StructCodec MultiKV layout.
+func NewMultiKVStore[T any](bucket string, id func(*T) int64) (*MultiKVStore[T], error) {
+	codec, err := CodecFor(typeOf[T]())
+	if err != nil {
+		return nil, err
+	}
+	if id, err = idAccessor(codec, id); err != nil {
+		return nil, err
+	}
+	return &MultiKVStore[T]{bucket: []byte(bucket), id: id, codec: codec}, nil
+}
+
+func (s *MultiKVStore[T]) Name() string { return "MultiKV[" + typeOf[T]().Name() + "]" }
+
+func (s *MultiKVStore[T]) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error { return setupFieldBucket(tx, s.bucket) })
+}
+
+func (s *MultiKVStore[T]) Write(db *bbolt.DB, v *T) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		return s.codec.PutMultiKV(tx.Bucket(s.bucket), s.id(v), v)
+	})
+}
+
+func (s *MultiKVStore[T]) WriteMany(db *bbolt.DB, vs []*T) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, v := range vs {
+			if err := s.codec.PutMultiKV(b, s.id(v), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *MultiKVStore[T]) get(b *bbolt.Bucket, id int64) (*T, error) {
+	v := new(T)
+	found, err := s.codec.GetMultiKV(b, id, v)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("record %d not found", id)
+	}
+	return v, nil
+}
+
+func (s *MultiKVStore[T]) Read(db *bbolt.DB, id int64) (*T, error) {
+	var v *T
+	err := db.View(func(tx *bbolt.Tx) error {
+		var err error
+		v, err = s.get(tx.Bucket(s.bucket), id)
+		return err
+	})
+	return v, err
+}
+
+// seekNextID moves a MultiKV cursor to the first key of the record after id.
+func seekNextID(c *bbolt.Cursor, id uint64) ([]byte, []byte) {
+	if id == ^uint64(0) {
+		return nil, nil
+	}
+	return c.Seek(binary.BigEndian.AppendUint64(nil, id+1))
+}
+
+func (s *MultiKVStore[T]) ReadMany(db *bbolt.DB, startId int64, count int) ([]*T, error) {
+	var vs []*T
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		c := b.Cursor()
+		for k, _ := c.Seek(idKey(startId)); k != nil && len(vs) < count; {
+			// Load the record, then skip past its remaining fields.
+			id := binary.BigEndian.Uint64(k)
+			v, err := s.get(b, int64(id))
+			if err != nil {
+				return err
+			}
+			vs = append(vs, v)
+			k, _ = seekNextID(c, id)
+		}
+		return nil
+	})
+	return vs, err
+}
+
+func (s *MultiKVStore[T]) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	data, err := s.codec.StoredValue(fieldName, value)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		key := idKey(id)
+		if k, _ := b.Cursor().Seek(key); k == nil || !bytes.HasPrefix(k, key) {
+			return fmt.Errorf("record %d not found", id)
+		}
+		return b.Put(append(key, fieldName...), data)
+	})
+}
+
+func (s *MultiKVStore[T]) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := s.codec.FieldNumber(new(T), fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		processed := 0
+		for k, _ := c.First(); k != nil && processed < count; {
+			// Jump to this record's field, then to the next record.
+			id := binary.BigEndian.Uint64(k)
+			v := new(T)
+			fieldKey := append(idKey(int64(id)), fieldName...)
+			if fk, data := c.Seek(fieldKey); bytes.Equal(fk, fieldKey) {
+				if err := s.codec.SetStored(v, fieldName, data); err != nil {
+					return err
+				}
+			}
+			n, err := s.codec.FieldNumber(v, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+			k, _ = seekNextID(c, id)
+		}
+		return nil
+	})
+	return sum, err
+}
+
+// NestedBucketStore stores each record of type T in a bucket of its own,
+// named by the 8-byte ID, with one key per field. The encoding version is
+// kept as in MultiKVStore.
+type NestedBucketStore[T any] struct {
+	bucket []byte
+	id     func(*T) int64
+	codec  *StructCodec
+}
+
+// NewNestedBucketStore returns a store with the StructCodec NestedBucket
+// layout.
+func NewNestedBucketStore[T any](bucket string, id func(*T) int64) (*NestedBucketStore[T], error) {
+	codec, err := CodecFor(typeOf[T]())
+	if err != nil {
+		return nil, err
+	}
+	if id, err = idAccessor(codec, id); err != nil {
+		return nil, err
+	}
+	return &NestedBucketStore[T]{bucket: []byte(bucket), id: id, codec: codec}, nil
+}
+
+func (s *NestedBucketStore[T]) Name() string { return "NestedBucket[" + typeOf[T]().Name() + "]" }
+
+func (s *NestedBucketStore[T]) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error { return setupFieldBucket(tx, s.bucket) })
+}
+
+func (s *NestedBucketStore[T]) Write(db *bbolt.DB, v *T) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		return s.codec.PutNested(tx.Bucket(s.bucket), s.id(v), v)
+	})
+}
+
+func (s *NestedBucketStore[T]) WriteMany(db *bbolt.DB, vs []*T) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(s.bucket)
+		for _, v := range vs {
+			if err := s.codec.PutNested(root, s.id(v), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *NestedBucketStore[T]) load(b *bbolt.Bucket) (*T, error) {
+	v := new(T)
+	if err := s.codec.GetNested(b, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *NestedBucketStore[T]) Read(db *bbolt.DB, id int64) (*T, error) {
+	var v *T
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(idKey(id))
+		if b == nil {
+			return fmt.Errorf("record %d not found", id)
+		}
+		var err error
+		v, err = s.load(b)
+		return err
+	})
+	return v, err
+}
+
+func (s *NestedBucketStore[T]) ReadMany(db *bbolt.DB, startId int64, count int) ([]*T, error) {
+	var vs []*T
+	err := db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(s.bucket)
+		c := root.Cursor()
+		for k, _ := c.Seek(idKey(startId)); k != nil && len(vs) < count; k, _ = c.Next() {
+			b := root.Bucket(k)
+			if b == nil {
+				continue
+			}
+			v, err := s.load(b)
+			if err != nil {
+				return err
+			}
+			vs = append(vs, v)
+		}
+		return nil
+	})
+	return vs, err
+}
+
+func (s *NestedBucketStore[T]) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	data, err := s.codec.StoredValue(fieldName, value)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(idKey(id))
+		if b == nil {
+			return fmt.Errorf("record %d not found", id)
+		}
+		return b.Put([]byte(fieldName), data)
+	})
+}
+
+func (s *NestedBucketStore[T]) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := s.codec.FieldNumber(new(T), fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(s.bucket)
+		c := root.Cursor()
+		processed := 0
+		for k, _ := c.First(); k != nil && processed < count; k, _ = c.Next() {
+			b := root.Bucket(k)
+			if b == nil {
+				continue
+			}
+			v := new(T)
+			if data := b.Get([]byte(fieldName)); data != nil {
+				if err := s.codec.SetStored(v, fieldName, data); err != nil {
+					return err
+				}
+			}
+			n, err := s.codec.FieldNumber(v, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *ValueStore[T]) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[T](db, s, s.bucket, corrupt)
+}
+
+func (s *MultiKVStore[T]) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyFieldsByRead[T](db, s, s.bucket, metaBucket(s.bucket), corrupt)
+}
+
+func (s *NestedBucketStore[T]) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyFieldsByRead[T](db, s, s.bucket, metaBucket(s.bucket), corrupt)
+}
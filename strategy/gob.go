@@ -131,6 +131,9 @@ func (s *GOBStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, valu
 }
 
 func (s *GOBStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
 	var sum float64
 	err := db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("users_gob"))
@@ -159,3 +162,23 @@ func (s *GOBStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (f
 	})
 	return sum, err
 }
+
+func (s *GOBStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(user); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *GOBStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	var user UserInfo
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *GOBStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_gob"), corrupt)
+}
@@ -0,0 +1,387 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"sync"
+)
+
+// 21. GOB with a shared type preamble
+//
+// GOBStrategy starts a new gob stream for every record, so every value
+// carries the full UserInfo type description. Here one encoder is kept for
+// the lifetime of the strategy: its first message, the type definitions
+// followed by a zero UserInfo, is stored once in the meta bucket as the
+// preamble, and each record stores only the value message that follows in
+// the stream. Decoders are primed by decoding the preamble first.
+//
+// Gob type IDs are assigned per process, so another process may produce a
+// different preamble. Every value therefore starts with the uvarint ID of
+// the preamble it was written after, and Setup stores a new preamble when
+// the current one is not this process's.
+//
+// Gob ignores bytes left over at the end of a message, so a decoded value
+// is not proof that the stored one is intact. Verify also re-encodes each
+// record and compares the fields with the stored ones.
+type GOBPreambleStrategy struct {
+	once     sync.Once
+	enc      *gob.Encoder
+	encBuf   bytes.Buffer
+	encMu    sync.Mutex
+	preamble []byte
+	err      error
+
+	mu       sync.Mutex
+	decoders map[string]*gobDecoder // keyed by preamble contents
+}
+
+var (
+	gobCurrentKey     = []byte("current")
+	gobPreamblePrefix = []byte("preamble/")
+)
+
+func (s *GOBPreambleStrategy) Name() string { return "GOB(preamble)" }
+
+func gobPreambleKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), gobPreamblePrefix...), id)
+}
+
+// encoder creates the shared encoder and records the preamble it wrote.
+func (s *GOBPreambleStrategy) encoder() ([]byte, error) {
+	s.once.Do(func() {
+		s.enc = gob.NewEncoder(&s.encBuf)
+		if s.err = s.enc.Encode(&UserInfo{}); s.err == nil {
+			s.preamble = append([]byte(nil), s.encBuf.Bytes()...)
+			s.encBuf.Reset()
+		}
+	})
+	return s.preamble, s.err
+}
+
+func (s *GOBPreambleStrategy) Setup(db *bbolt.DB) error {
+	preamble, err := s.encoder()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("users_gob_preamble")); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte("users_gob_meta"))
+		if err != nil {
+			return err
+		}
+		if cur := meta.Get(gobCurrentKey); cur != nil {
+			if bytes.Equal(meta.Get(gobPreambleKey(binary.BigEndian.Uint64(cur))), preamble) {
+				return nil
+			}
+		}
+		id, err := meta.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(gobPreambleKey(id), preamble); err != nil {
+			return err
+		}
+		return meta.Put(gobCurrentKey, binary.BigEndian.AppendUint64(nil, id))
+	})
+}
+
+// encode returns the value message of user, prefixed with the ID of the
+// current preamble.
+func (s *GOBPreambleStrategy) encode(meta *bbolt.Bucket, user *UserInfo) ([]byte, error) {
+	cur := meta.Get(gobCurrentKey)
+	if cur == nil {
+		return nil, fmt.Errorf("gob: no preamble, Setup was not called")
+	}
+	return s.appendValue(binary.AppendUvarint(nil, binary.BigEndian.Uint64(cur)), user)
+}
+
+// appendValue appends the value message of user in the shared stream.
+func (s *GOBPreambleStrategy) appendValue(buf []byte, user *UserInfo) ([]byte, error) {
+	if _, err := s.encoder(); err != nil {
+		return nil, err
+	}
+	s.encMu.Lock()
+	defer s.encMu.Unlock()
+	s.encBuf.Reset()
+	if err := s.enc.Encode(user); err != nil {
+		return nil, err
+	}
+	return append(buf, s.encBuf.Bytes()...), nil
+}
+
+// gobDecoder is a decoder primed with one preamble. Its reader is swapped
+// to each value in turn; being an io.ByteReader, gob reads from it without
+// buffering ahead.
+type gobDecoder struct {
+	mu  sync.Mutex
+	r   bytes.Reader
+	dec *gob.Decoder
+}
+
+func newGOBDecoder(preamble []byte) (*gobDecoder, error) {
+	d := &gobDecoder{}
+	d.r.Reset(preamble)
+	d.dec = gob.NewDecoder(&d.r)
+	var zero UserInfo
+	if err := d.dec.Decode(&zero); err != nil {
+		return nil, fmt.Errorf("gob: bad preamble: %w", err)
+	}
+	return d, nil
+}
+
+func (d *gobDecoder) decode(data []byte) (*UserInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.r.Reset(data)
+	var user UserInfo
+	if err := d.dec.Decode(&user); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if d.r.Len() != 0 {
+		return nil, fmt.Errorf("gob: %d trailing bytes", d.r.Len())
+	}
+	return &user, nil
+}
+
+func (s *GOBPreambleStrategy) decoder(preamble []byte) (*gobDecoder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.decoders[string(preamble)]; ok {
+		return d, nil
+	}
+	d, err := newGOBDecoder(preamble)
+	if err != nil {
+		return nil, err
+	}
+	if s.decoders == nil {
+		s.decoders = make(map[string]*gobDecoder)
+	}
+	s.decoders[string(preamble)] = d
+	return d, nil
+}
+
+func (s *GOBPreambleStrategy) decode(meta *bbolt.Bucket, data []byte) (*UserInfo, error) {
+	id, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("gob: bad preamble id")
+	}
+	preamble := meta.Get(gobPreambleKey(id))
+	if preamble == nil {
+		return nil, fmt.Errorf("gob preamble %d not found", id)
+	}
+	d, err := s.decoder(preamble)
+	if err != nil {
+		return nil, err
+	}
+	return d.decode(data[n:])
+}
+
+func (s *GOBPreambleStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_gob_preamble"))
+		data, err := s.encode(tx.Bucket([]byte("users_gob_meta")), user)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, data)
+	})
+}
+
+func (s *GOBPreambleStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_gob_preamble"))
+		meta := tx.Bucket([]byte("users_gob_meta"))
+		for _, user := range users {
+			data, err := s.encode(meta, user)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *GOBPreambleStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_gob_preamble"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decode(tx.Bucket([]byte("users_gob_meta")), data)
+		return err
+	})
+	return user, err
+}
+
+func (s *GOBPreambleStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_gob_preamble")).Cursor()
+		meta := tx.Bucket([]byte("users_gob_meta"))
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decode(meta, v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *GOBPreambleStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_gob_preamble"))
+		meta := tx.Bucket([]byte("users_gob_meta"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+
+		user, err := s.decode(meta, data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		newData, err := s.encode(meta, user)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *GOBPreambleStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_gob_preamble")).Cursor()
+		meta := tx.Bucket([]byte("users_gob_meta"))
+		processed := 0
+
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			user, err := s.decode(meta, v)
+			if err != nil {
+				return err
+			}
+			n, _ := userFieldNumber(user, fieldName)
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *GOBPreambleStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_gob_preamble"))
+		if b == nil {
+			return fmt.Errorf("bucket users_gob_preamble not found")
+		}
+		meta := tx.Bucket([]byte("users_gob_meta"))
+		if meta == nil {
+			return fmt.Errorf("bucket users_gob_meta not found")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != 8 {
+				return fmt.Errorf("bucket users_gob_preamble: key %x is not a record ID", k)
+			}
+			if err := s.verifyValue(meta, v); err != nil {
+				corrupt(int64(binary.BigEndian.Uint64(k)), err)
+			}
+			return nil
+		})
+	})
+}
+
+// verifyValue decodes a stored value and checks that it holds exactly the
+// fields the decoded record encodes to.
+func (s *GOBPreambleStrategy) verifyValue(meta *bbolt.Bucket, data []byte) error {
+	user, err := s.decode(meta, data)
+	if err != nil {
+		return err
+	}
+	_, n := binary.Uvarint(data)
+	stored, err := gobValueFields(data[n:])
+	if err != nil {
+		return err
+	}
+	msg, err := s.appendValue(nil, user)
+	if err != nil {
+		return err
+	}
+	want, err := gobValueFields(msg)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored, want) {
+		return fmt.Errorf("gob: stored value differs from its re-encoding")
+	}
+	return nil
+}
+
+// gobValueFields returns the encoded fields of a gob value message, without
+// the message length and the type ID, which depend on the stream.
+func gobValueFields(msg []byte) ([]byte, error) {
+	size, rest, ok := gobUint(msg)
+	if !ok || size != uint64(len(rest)) {
+		return nil, fmt.Errorf("gob: bad message length")
+	}
+	if _, rest, ok = gobUint(rest); !ok {
+		return nil, fmt.Errorf("gob: bad type id")
+	}
+	return rest, nil
+}
+
+// gobUint reads an unsigned integer in gob's encoding: one byte below 0x80,
+// or the negated byte count followed by the big-endian value.
+func gobUint(data []byte) (uint64, []byte, bool) {
+	if len(data) == 0 {
+		return 0, nil, false
+	}
+	if data[0] < 0x80 {
+		return uint64(data[0]), data[1:], true
+	}
+	n := int(-int8(data[0]))
+	if n > 8 || len(data) < 1+n {
+		return 0, nil, false
+	}
+	var v uint64
+	for _, b := range data[1 : 1+n] {
+		v = v<<8 | uint64(b)
+	}
+	return v, data[1+n:], true
+}
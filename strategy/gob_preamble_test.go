@@ -0,0 +1,47 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// Gob skips bytes left over at the end of a message, so a value padded
+// inside its message still decodes; Verify must report it anyway.
+func TestGOBPreambleVerifyPadding(t *testing.T) {
+	s := &GOBPreambleStrategy{}
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "gob.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := s.Setup(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{1, 2} {
+		if err := s.Write(db, &UserInfo{ID: id, Username: "user"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_gob_preamble"))
+		data := append([]byte(nil), b.Get(idKey(2))...)
+		_, n := binary.Uvarint(data)
+		data[n] += 3 // message length, a single byte for a small record
+		return b.Put(idKey(2), append(data, 1, 2, 3))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bad []int64
+	err = Verify(db, s, func(id int64, err error) { bad = append(bad, id) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bad) != 1 || bad[0] != 2 {
+		t.Fatalf("Verify reported %v, want [2]", bad)
+	}
+}
@@ -0,0 +1,288 @@
+package strategy_test
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "boltdb_benchmarks/strategy"
+	"go.etcd.io/bbolt"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenRecords are the records stored in the golden files. Changing them
+// changes every file; add records rather than edit them.
+var goldenRecords = []*UserInfo{
+	{ID: 0},
+	{
+		ID: 1, Username: "alice", Email: "alice@example.com", FirstName: "Alice", LastName: "Liddell",
+		Age: 31, Height: 165.5, Weight: 58.25, Balance: 1234.5, IsActive: true,
+		CreatedAt: 1_700_000_000, UpdatedAt: 1_700_086_400, LoginCount: 42, Score: 0.75,
+		Description: "plain ASCII",
+	},
+	{
+		ID: 1 << 40, Username: "bøb", Email: "bob@example.com", FirstName: "Bob", LastName: "Ünïcødé ✓",
+		Age: -1, Height: -0.5, Weight: 1e-3, Balance: -98765.4321, IsActive: false,
+		CreatedAt: -1, UpdatedAt: 1 << 62, LoginCount: -2147483648, Score: -1e300,
+		Description: "comma, \"quotes\"\nand a newline",
+	},
+}
+
+// goldenStrategies are the formats persisted in production. Their golden
+// files freeze the exact keys and values they write.
+var goldenStrategies = []struct {
+	file string
+	new  func() StorageStrategy
+	// fresh encodes in a new test process, for formats that depend on
+	// process state: gob numbers types in the order a process first uses
+	// them.
+	fresh bool
+}{
+	{"binary", func() StorageStrategy { return &BinaryStrategy{} }, false},
+	{"binary_names", func() StorageStrategy { return &BinaryWithNamesStrategy{} }, false},
+	{"json", func() StorageStrategy { return &JSONStrategy{} }, false},
+	{"gob", func() StorageStrategy { return &GOBStrategy{} }, true},
+	{"multikv", func() StorageStrategy { return &MultiKVStrategy{} }, false},
+	{"nested", func() StorageStrategy { return &NestedBucketStrategy{} }, false},
+}
+
+// goldenChildEnv is set in the process runFresh starts.
+const goldenChildEnv = "GOLDEN_FRESH_PROCESS"
+
+// runFresh runs the subtest TestGoldenEncode/file alone in a new process
+// and fails if it does.
+func runFresh(t *testing.T, file string) {
+	args := []string{"-test.run=^TestGoldenEncode$/^" + file + "$", "-test.v"}
+	if *update {
+		args = append(args, "-update")
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), goldenChildEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if !bytes.Contains(out, []byte("--- PASS: TestGoldenEncode/"+file)) {
+		t.Fatalf("the new process did not run the test:\n%s", out)
+	}
+}
+
+// A golden file lists every bucket, key and value in the database, one
+// per line, in bbolt's order:
+//
+//	"bucket" ["nested bucket" ...] "key" "value"
+//
+// each a Go string literal. A nested bucket itself is listed with the
+// value -.
+const goldenHeader = "# Written by go test -run TestGolden -update. Do not edit.\n"
+
+func goldenPath(file string) string {
+	return filepath.Join("testdata", "golden", file+".txt")
+}
+
+// dumpDB writes the contents of db in the golden file format.
+func dumpDB(db *bbolt.DB) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(goldenHeader)
+	var walk func(path string, b *bbolt.Bucket) error
+	walk = func(path string, b *bbolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				fmt.Fprintf(&buf, "%s %q %q\n", path, k, v)
+				return nil
+			}
+			fmt.Fprintf(&buf, "%s %q -\n", path, k)
+			return walk(fmt.Sprintf("%s %q", path, k), b.Bucket(k))
+		})
+	}
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			return walk(strconv.Quote(string(name)), b)
+		})
+	})
+	return buf.Bytes(), err
+}
+
+// parseGoldenLine splits a line of a golden file into the bucket path, the
+// key and the value, which is nil for a nested bucket.
+func parseGoldenLine(line string) (path [][]byte, key, value []byte, err error) {
+	var tokens [][]byte
+	nested := false
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line == "-" {
+			nested = true
+			break
+		}
+		lit, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		s, _ := strconv.Unquote(lit)
+		tokens = append(tokens, []byte(s))
+		line = line[len(lit):]
+	}
+	if !nested {
+		if len(tokens) < 3 {
+			return nil, nil, nil, fmt.Errorf("want a bucket, a key and a value")
+		}
+		value, tokens = tokens[len(tokens)-1], tokens[:len(tokens)-1]
+	} else if len(tokens) < 2 {
+		return nil, nil, nil, fmt.Errorf("want a bucket and a key")
+	}
+	return tokens[:len(tokens)-1], tokens[len(tokens)-1], value, nil
+}
+
+// loadDB fills db with the contents of a golden file.
+func loadDB(db *bbolt.DB, golden []byte) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		sc := bufio.NewScanner(bytes.NewReader(golden))
+		for line := 1; sc.Scan(); line++ {
+			text := sc.Text()
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			path, k, v, err := parseGoldenLine(text)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			b, err := tx.CreateBucketIfNotExists(path[0])
+			for _, name := range path[1:] {
+				if err != nil {
+					break
+				}
+				b, err = b.CreateBucketIfNotExists(name)
+			}
+			if err == nil {
+				if v == nil {
+					_, err = b.CreateBucketIfNotExists(k)
+				} else {
+					err = b.Put(k, v)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		return sc.Err()
+	})
+}
+
+func openTemp(t *testing.T) *bbolt.DB {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "golden.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestGoldenEncode fails when a strategy no longer writes exactly the
+// bytes in its golden file.
+func TestGoldenEncode(t *testing.T) {
+	for _, g := range goldenStrategies {
+		t.Run(g.file, func(t *testing.T) {
+			if g.fresh && os.Getenv(goldenChildEnv) == "" {
+				runFresh(t, g.file)
+				return
+			}
+			db := openTemp(t)
+			s := g.new()
+			if err := s.Setup(db); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.WriteMany(db, goldenRecords); err != nil {
+				t.Fatal(err)
+			}
+			got, err := dumpDB(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := goldenPath(g.file)
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s writes a different layout than %s; if the change is intended, "+
+					"keep a decoder for the old layout and run go test -run TestGolden -update.\n%s",
+					s.Name(), path, lineDiff(string(want), string(got)))
+			}
+		})
+	}
+}
+
+// TestGoldenDecode reads the records back from the checked-in golden
+// files, so that data already on disk stays readable.
+func TestGoldenDecode(t *testing.T) {
+	for _, g := range goldenStrategies {
+		t.Run(g.file, func(t *testing.T) {
+			golden, err := os.ReadFile(goldenPath(g.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			db := openTemp(t)
+			if err := loadDB(db, golden); err != nil {
+				t.Fatalf("%s: %v", goldenPath(g.file), err)
+			}
+			s := g.new()
+			if err := s.Setup(db); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range goldenRecords {
+				got, err := s.Read(db, want.ID)
+				if err != nil {
+					t.Errorf("Read(%d): %v", want.ID, err)
+					continue
+				}
+				if *got != *want {
+					t.Errorf("Read(%d):\n got  %+v\n want %+v", want.ID, *got, *want)
+				}
+			}
+			all, err := s.ReadMany(db, 0, len(goldenRecords)+1)
+			if err != nil {
+				t.Fatalf("ReadMany: %v", err)
+			}
+			if len(all) != len(goldenRecords) {
+				t.Errorf("ReadMany returned %d records, want %d", len(all), len(goldenRecords))
+			}
+		})
+	}
+}
+
+// lineDiff lists the lines of want and got that differ, by position.
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var b strings.Builder
+	for i := 0; i < max(len(w), len(g)); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			fmt.Fprintf(&b, "line %d:\n- %s\n+ %s\n", i+1, wl, gl)
+		}
+	}
+	return b.String()
+}
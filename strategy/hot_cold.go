@@ -0,0 +1,266 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// 7. Hot/cold split strategy
+//
+// The profile ("cold") fields of a record live in one compact value, and the
+// frequently updated ("hot") fields live in a sibling bucket under the same
+// key. Updating a hot field only rewrites the small hot value.
+type HotColdStrategy struct {
+	// HotFields names the fields stored in the hot bucket.
+	// Defaults to balance, login_count and score.
+	HotFields []string
+}
+
+var defaultHotFields = []string{"balance", "login_count", "score"}
+
+func (s *HotColdStrategy) Name() string { return "HotCold" }
+
+func (s *HotColdStrategy) Setup(db *bbolt.DB) error {
+	if _, _, err := s.split(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("users_hotcold")); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte("users_hotcold_hot"))
+		return err
+	})
+}
+
+// split returns the indexes into userFields of the hot and cold fields.
+func (s *HotColdStrategy) split() (hot, cold []int, err error) {
+	names := s.HotFields
+	if names == nil {
+		names = defaultHotFields
+	}
+	isHot := make([]bool, len(userFields))
+	for _, name := range names {
+		i, ok := lookupUserField(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown hot field %q", name)
+		}
+		if name == "id" {
+			return nil, nil, fmt.Errorf("id cannot be a hot field")
+		}
+		isHot[i] = true
+	}
+	for i := range userFields {
+		if isHot[i] {
+			hot = append(hot, i)
+		} else {
+			cold = append(cold, i)
+		}
+	}
+	return hot, cold, nil
+}
+
+func (s *HotColdStrategy) encodeFields(user *UserInfo, fields []int) []byte {
+	var buf []byte
+	for _, i := range fields {
+		buf = appendPackedField(buf, &userFields[i], user)
+	}
+	return buf
+}
+
+func (s *HotColdStrategy) decodeFields(data []byte, user *UserInfo, fields []int) error {
+	var err error
+	for _, i := range fields {
+		if data, err = decodePackedField(data, &userFields[i], user); err != nil {
+			return err
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%d trailing bytes", len(data))
+	}
+	return nil
+}
+
+func (s *HotColdStrategy) writeUser(cold, hot *bbolt.Bucket, user *UserInfo, hotFields, coldFields []int) error {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(user.ID))
+	if err := cold.Put(key, s.encodeFields(user, coldFields)); err != nil {
+		return err
+	}
+	return hot.Put(key, s.encodeFields(user, hotFields))
+}
+
+func (s *HotColdStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	hotFields, coldFields, err := s.split()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		cold := tx.Bucket([]byte("users_hotcold"))
+		hot := tx.Bucket([]byte("users_hotcold_hot"))
+		return s.writeUser(cold, hot, user, hotFields, coldFields)
+	})
+}
+
+func (s *HotColdStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	hotFields, coldFields, err := s.split()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		cold := tx.Bucket([]byte("users_hotcold"))
+		hot := tx.Bucket([]byte("users_hotcold_hot"))
+		for _, user := range users {
+			if err := s.writeUser(cold, hot, user, hotFields, coldFields); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *HotColdStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	hotFields, coldFields, err := s.split()
+	if err != nil {
+		return nil, err
+	}
+	user := &UserInfo{}
+	err = db.View(func(tx *bbolt.Tx) error {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		coldData := tx.Bucket([]byte("users_hotcold")).Get(key)
+		hotData := tx.Bucket([]byte("users_hotcold_hot")).Get(key)
+		if coldData == nil || hotData == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		if err := s.decodeFields(coldData, user, coldFields); err != nil {
+			return fmt.Errorf("user %d cold fields: %w", id, err)
+		}
+		if err := s.decodeFields(hotData, user, hotFields); err != nil {
+			return fmt.Errorf("user %d hot fields: %w", id, err)
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (s *HotColdStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	hotFields, coldFields, err := s.split()
+	if err != nil {
+		return nil, err
+	}
+	var users []*UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		hotBucket := tx.Bucket([]byte("users_hotcold_hot"))
+		c := tx.Bucket([]byte("users_hotcold")).Cursor()
+		hc := hotBucket.Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		// Both buckets share their keys, so walk them in lockstep.
+		hk, hv := hc.Seek(startKey)
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			if !bytes.Equal(k, hk) {
+				hv = hotBucket.Get(k)
+				if hv == nil {
+					return fmt.Errorf("user %d: missing hot fields", binary.BigEndian.Uint64(k))
+				}
+			}
+			user := &UserInfo{}
+			if err := s.decodeFields(v, user, coldFields); err != nil {
+				return err
+			}
+			if err := s.decodeFields(hv, user, hotFields); err != nil {
+				return err
+			}
+			users = append(users, user)
+			hk, hv = hc.Next()
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *HotColdStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	hotFields, coldFields, err := s.split()
+	if err != nil {
+		return err
+	}
+	i, ok := lookupUserField(fieldName)
+	if !ok {
+		return fmt.Errorf("field %q is not updatable", fieldName)
+	}
+
+	// Only the value holding the field is decoded and rewritten.
+	bucketName, fields := "users_hotcold", coldFields
+	for _, h := range hotFields {
+		if h == i {
+			bucketName, fields = "users_hotcold_hot", hotFields
+			break
+		}
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+
+		var user UserInfo
+		if err := s.decodeFields(data, &user, fields); err != nil {
+			return err
+		}
+		if err := setUserField(&user, fieldName, value); err != nil {
+			return err
+		}
+		return b.Put(key, s.encodeFields(&user, fields))
+	})
+}
+
+func (s *HotColdStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	hotFields, coldFields, err := s.split()
+	if err != nil {
+		return 0, err
+	}
+	i, ok := lookupUserField(fieldName)
+	if !ok {
+		return 0, fmt.Errorf("cannot sum field %q", fieldName)
+	}
+	bucketName, fields := "users_hotcold", coldFields
+	for _, h := range hotFields {
+		if h == i {
+			bucketName, fields = "users_hotcold_hot", hotFields
+			break
+		}
+	}
+
+	var sum float64
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(bucketName)).Cursor()
+		processed := 0
+		var user UserInfo
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			if err := s.decodeFields(v, &user, fields); err != nil {
+				return err
+			}
+			n, err := userFieldNumber(&user, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *HotColdStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_hotcold"), corrupt)
+}
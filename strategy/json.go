@@ -123,6 +123,9 @@ func (s *JSONStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, val
 }
 
 func (s *JSONStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
 	var sum float64
 	err := db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("users_json"))
@@ -149,3 +152,19 @@ func (s *JSONStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (
 	})
 	return sum, err
 }
+
+func (s *JSONStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return json.Marshal(user)
+}
+
+func (s *JSONStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	var user UserInfo
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *JSONStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_json"), corrupt)
+}
@@ -0,0 +1,268 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"strconv"
+)
+
+// 24. Positional JSON strategy
+//
+// Still JSON, but a record is an array of its field values in declaration
+// order instead of an object, so the field names are not repeated in every
+// value:
+//
+//	[17,"user_17","user17@example.com",...]
+//
+// ReadFieldSum finds the field by its index with a small scanner instead
+// of decoding the whole array.
+type JSONArrayStrategy struct{}
+
+func (s *JSONArrayStrategy) Name() string { return "JSON(array)" }
+
+func (s *JSONArrayStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_json_array"))
+		return err
+	})
+}
+
+// jsonArrayFields returns pointers to the fields of user in userFields
+// order; json marshals through them and unmarshals into them.
+func jsonArrayFields(user *UserInfo) []interface{} {
+	return []interface{}{
+		&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName,
+		&user.Age, &user.Height, &user.Weight, &user.Balance, &user.IsActive,
+		&user.CreatedAt, &user.UpdatedAt, &user.LoginCount, &user.Score, &user.Description,
+	}
+}
+
+func (s *JSONArrayStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return json.Marshal(jsonArrayFields(user))
+}
+
+func (s *JSONArrayStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	user := &UserInfo{}
+	fields := jsonArrayFields(user)
+	// Unmarshal decodes into the pointers already in the slice; extra
+	// elements would be appended and missing ones shrink it.
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if len(fields) != len(userFields) {
+		return nil, fmt.Errorf("json array: want %d fields, got %d", len(userFields), len(fields))
+	}
+	return user, nil
+}
+
+func isJSONSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
+
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) && isJSONSpace(data[i]) {
+		i++
+	}
+	return i
+}
+
+// skipJSONValue returns the end of the JSON value starting at data[i]. It
+// only finds the boundary; the value itself is not validated.
+func skipJSONValue(data []byte, i int) (int, error) {
+	depth := 0
+	for i < len(data) {
+		switch c := data[i]; c {
+		case '"':
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(data) {
+				return 0, fmt.Errorf("json array: unterminated string")
+			}
+			i++
+		case '[', '{':
+			depth++
+			i++
+		case ']', '}':
+			if depth == 0 {
+				return i, nil
+			}
+			depth--
+			i++
+		case ',':
+			if depth == 0 {
+				return i, nil
+			}
+			i++
+		default:
+			if depth == 0 && isJSONSpace(c) {
+				return i, nil
+			}
+			i++
+		}
+	}
+	if depth != 0 {
+		return 0, fmt.Errorf("json array: unexpected end of input")
+	}
+	return i, nil
+}
+
+// jsonArrayElem returns the raw bytes of the index-th element of a JSON
+// array.
+func jsonArrayElem(data []byte, index int) ([]byte, error) {
+	i := skipJSONSpace(data, 0)
+	if i >= len(data) || data[i] != '[' {
+		return nil, fmt.Errorf("json array: not an array")
+	}
+	i++
+	for n := 0; ; n++ {
+		i = skipJSONSpace(data, i)
+		if i < len(data) && data[i] == ']' {
+			return nil, fmt.Errorf("json array: no element %d", index)
+		}
+		end, err := skipJSONValue(data, i)
+		if err != nil {
+			return nil, err
+		}
+		if end == i {
+			return nil, fmt.Errorf("json array: missing element %d", n)
+		}
+		if n == index {
+			return data[i:end], nil
+		}
+		i = skipJSONSpace(data, end)
+		if i >= len(data) || data[i] != ',' {
+			return nil, fmt.Errorf("json array: no element %d", index)
+		}
+		i++
+	}
+}
+
+func (s *JSONArrayStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_json_array"))
+		data, err := s.encodeValue(user)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, data)
+	})
+}
+
+func (s *JSONArrayStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_json_array"))
+		for _, user := range users {
+			data, err := s.encodeValue(user)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *JSONArrayStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_json_array"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeValue(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *JSONArrayStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_json_array"))
+		c := b.Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeValue(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *JSONArrayStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_json_array"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+
+		user, err := s.decodeValue(data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		newData, err := s.encodeValue(user)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *JSONArrayStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	index, _ := lookupUserField(fieldName)
+
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_json_array"))
+		c := b.Cursor()
+		processed := 0
+
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			elem, err := jsonArrayElem(v, index)
+			if err != nil {
+				return err
+			}
+			n, err := strconv.ParseFloat(string(elem), 64)
+			if err != nil {
+				return fmt.Errorf("json array: %s: %w", fieldName, err)
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *JSONArrayStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_json_array"), corrupt)
+}
@@ -0,0 +1,151 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"boltdb_benchmarks/strategy/fieldcodec"
+	"go.etcd.io/bbolt"
+)
+
+// legacyFields is a record in the encoding MultiKV and NestedBucket used
+// before fieldcodec.
+func legacyFields() map[string][]byte {
+	f32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	f64 := func(v uint64) []byte { return binary.LittleEndian.AppendUint64(nil, v) }
+	return map[string][]byte{
+		"id":          []byte("7"),
+		"username":    []byte("user_7"),
+		"age":         []byte("-31"),
+		"height":      f32(180),
+		"balance":     f64(1234),
+		"is_active":   []byte("true"),
+		"created_at":  []byte(strconv.FormatInt(1_700_000_000, 10)),
+		"login_count": []byte("42"),
+		"score":       f64(3),
+	}
+}
+
+var legacyUser = UserInfo{
+	ID: 7, Username: "user_7", Age: -31, Height: 180, Balance: 1234,
+	IsActive: true, CreatedAt: 1_700_000_000, LoginCount: 42, Score: 3,
+}
+
+func TestMigrateLegacyFields(t *testing.T) {
+	tests := []struct {
+		s interface {
+			StorageStrategy
+			Migrate(*bbolt.DB) error
+		}
+		write func(tx *bbolt.Tx) error
+	}{
+		{&MultiKVStrategy{}, func(tx *bbolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("users_multikv"))
+			if err != nil {
+				return err
+			}
+			for name, v := range legacyFields() {
+				if err := b.Put(append(idKey(7), name...), v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{&NestedBucketStrategy{}, func(tx *bbolt.Tx) error {
+			root, err := tx.CreateBucket([]byte("users_nested"))
+			if err != nil {
+				return err
+			}
+			b, err := root.CreateBucket(idKey(7))
+			if err != nil {
+				return err
+			}
+			for name, v := range legacyFields() {
+				if err := b.Put([]byte(name), v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s.Name(), func(t *testing.T) {
+			db, err := bbolt.Open(filepath.Join(t.TempDir(), "legacy.db"), 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if err := db.Update(tt.write); err != nil {
+				t.Fatal(err)
+			}
+
+			// Verify runs on databases never passed to Setup.
+			corrupt := func(id int64, err error) { t.Errorf("Verify on legacy data: %d: %v", id, err) }
+			if err := Verify(db, tt.s, corrupt); !errors.Is(err, fieldcodec.ErrLegacyFormat) {
+				t.Fatalf("Verify on legacy data = %v, want ErrLegacyFormat", err)
+			}
+			if err := tt.s.Setup(db); !errors.Is(err, fieldcodec.ErrLegacyFormat) {
+				t.Fatalf("Setup on legacy data = %v, want ErrLegacyFormat", err)
+			}
+			if err := tt.s.Migrate(db); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			if err := tt.s.Setup(db); err != nil {
+				t.Fatalf("Setup after Migrate: %v", err)
+			}
+			if err := Verify(db, tt.s, corrupt); err != nil {
+				t.Errorf("Verify after Migrate: %v", err)
+			}
+			got, err := tt.s.Read(db, 7)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if *got != legacyUser {
+				t.Errorf("Read after Migrate:\n got  %+v\n want %+v", *got, legacyUser)
+			}
+			// A second Migrate has nothing to do.
+			if err := tt.s.Migrate(db); err != nil {
+				t.Errorf("second Migrate: %v", err)
+			}
+		})
+	}
+}
+
+// The Reflect layouts have no Migrate; they only refuse data without a
+// version, which predates their fieldcodec encoding.
+func TestReflectRejectsLegacyFields(t *testing.T) {
+	for layout, meta := range map[Layout]string{
+		LayoutMultiKV:      "users_reflect_multikv_meta",
+		LayoutNestedBucket: "users_reflect_nested_meta",
+	} {
+		s := &ReflectStrategy{Layout: layout}
+		t.Run(s.Name(), func(t *testing.T) {
+			db, err := bbolt.Open(filepath.Join(t.TempDir(), "legacy.db"), 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if err := s.Setup(db); err != nil {
+				t.Fatalf("Setup: %v", err)
+			}
+			if err := s.Write(db, &legacyUser); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Setup(db); err != nil {
+				t.Fatalf("second Setup: %v", err)
+			}
+			err = db.Update(func(tx *bbolt.Tx) error {
+				return tx.DeleteBucket([]byte(meta))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Setup(db); !errors.Is(err, fieldcodec.ErrLegacyFormat) {
+				t.Fatalf("Setup without a version = %v, want ErrLegacyFormat", err)
+			}
+		})
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"go.etcd.io/bbolt"
 )
 
-// Storage strategy interface
-type StorageStrategy interface {
+// Store is a storage strategy for records of type T
+type Store[T any] interface {
 	Name() string
-	Write(db *bbolt.DB, user *UserInfo) error
-	WriteMany(db *bbolt.DB, users []*UserInfo) error
-	Read(db *bbolt.DB, id int64) (*UserInfo, error)
-	ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error)
+	Write(db *bbolt.DB, record *T) error
+	WriteMany(db *bbolt.DB, records []*T) error
+	Read(db *bbolt.DB, id int64) (*T, error)
+	ReadMany(db *bbolt.DB, startId int64, count int) ([]*T, error)
 	UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error
 	ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error)
 	Setup(db *bbolt.DB) error
 }
+
+// Storage strategy interface
+type StorageStrategy = Store[UserInfo]
@@ -0,0 +1,561 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+)
+
+// 15. MessagePack strategy
+//
+// Stores each record as a MessagePack map keyed by the json field names, or,
+// in ArrayMode, as an array of the values in userFields order. Integers use
+// the shortest encoding; floats keep their Go width. The encoder and decoder
+// below cover the subset of the format UserInfo needs, but the decoder skips
+// any well-formed value it does not expect, so keys added by other writers
+// are ignored.
+type MsgPackStrategy struct {
+	ArrayMode bool
+}
+
+func (s *MsgPackStrategy) Name() string {
+	if s.ArrayMode {
+		return "MsgPack(array)"
+	}
+	return "MsgPack"
+}
+
+func (s *MsgPackStrategy) bucket() []byte {
+	if s.ArrayMode {
+		return []byte("users_msgpack_array")
+	}
+	return []byte("users_msgpack")
+}
+
+func (s *MsgPackStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket())
+		return err
+	})
+}
+
+func appendMsgpackInt(buf []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= math.MaxInt8:
+		return append(buf, byte(v))
+	case v >= -32 && v < 0:
+		return append(buf, byte(v))
+	case v >= 0 && v <= math.MaxUint8:
+		return append(buf, 0xcc, byte(v))
+	case v >= 0 && v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(v))
+	case v >= 0 && v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(v))
+	case v >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(v))
+	case v >= math.MinInt8:
+		return append(buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(v))
+}
+
+func appendMsgpackString(buf []byte, str string) []byte {
+	switch n := len(str); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, str...)
+}
+
+// appendMsgpackHeader writes a map or array header; fix, m16 and m32 are the
+// three type bytes of the container.
+func appendMsgpackHeader(buf []byte, n int, fix, m16, m32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, m16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, m32), uint32(n))
+}
+
+// appendMsgpackField writes the value of userFields[i].
+func appendMsgpackField(buf []byte, i int, user *UserInfo) []byte {
+	switch userFields[i].name {
+	case "id":
+		return appendMsgpackInt(buf, user.ID)
+	case "username":
+		return appendMsgpackString(buf, user.Username)
+	case "email":
+		return appendMsgpackString(buf, user.Email)
+	case "first_name":
+		return appendMsgpackString(buf, user.FirstName)
+	case "last_name":
+		return appendMsgpackString(buf, user.LastName)
+	case "age":
+		return appendMsgpackInt(buf, int64(user.Age))
+	case "height":
+		return binary.BigEndian.AppendUint32(append(buf, 0xca), math.Float32bits(user.Height))
+	case "weight":
+		return binary.BigEndian.AppendUint32(append(buf, 0xca), math.Float32bits(user.Weight))
+	case "balance":
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(user.Balance))
+	case "is_active":
+		if user.IsActive {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case "created_at":
+		return appendMsgpackInt(buf, user.CreatedAt)
+	case "updated_at":
+		return appendMsgpackInt(buf, user.UpdatedAt)
+	case "login_count":
+		return appendMsgpackInt(buf, int64(user.LoginCount))
+	case "score":
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(user.Score))
+	case "description":
+		return appendMsgpackString(buf, user.Description)
+	}
+	panic("msgpack: unhandled field " + userFields[i].name)
+}
+
+func (s *MsgPackStrategy) encodeMsgpack(user *UserInfo) []byte {
+	buf := make([]byte, 0, 192+len(user.Username)+len(user.Email)+
+		len(user.FirstName)+len(user.LastName)+len(user.Description))
+	if s.ArrayMode {
+		buf = appendMsgpackHeader(buf, len(userFields), 0x90, 0xdc, 0xdd)
+	} else {
+		buf = appendMsgpackHeader(buf, len(userFields), 0x80, 0xde, 0xdf)
+	}
+	for i := range userFields {
+		if !s.ArrayMode {
+			buf = appendMsgpackString(buf, userFields[i].name)
+		}
+		buf = appendMsgpackField(buf, i, user)
+	}
+	return buf
+}
+
+// msgpackMaxDepth bounds the nesting of values the decoder will skip.
+const msgpackMaxDepth = 32
+
+// msgpackReader consumes a MessagePack value, remembering the first error.
+type msgpackReader struct {
+	data []byte
+	err  error
+}
+
+func (r *msgpackReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("msgpack: "+format, args...)
+	}
+	r.data = nil
+}
+
+func (r *msgpackReader) next(n int) []byte {
+	if n < 0 || len(r.data) < n {
+		r.fail("truncated")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *msgpackReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *msgpackReader) uint(n int) uint64 {
+	b := r.next(n)
+	switch {
+	case b == nil:
+		return 0
+	case n == 1:
+		return uint64(b[0])
+	case n == 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	case n == 4:
+		return uint64(binary.BigEndian.Uint32(b))
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// header reads a map (fix 0x80) or array (fix 0x90) header.
+func (r *msgpackReader) header(fix, m16, m32 byte, what string) int {
+	switch t := r.byte(); {
+	case r.err != nil:
+	case t&0xf0 == fix:
+		return int(t & 0x0f)
+	case t == m16:
+		return int(r.uint(2))
+	case t == m32:
+		return int(r.uint(4))
+	default:
+		r.fail("expected %s, got type 0x%02x", what, t)
+	}
+	return 0
+}
+
+func (r *msgpackReader) mapLen() int   { return r.header(0x80, 0xde, 0xdf, "map") }
+func (r *msgpackReader) arrayLen() int { return r.header(0x90, 0xdc, 0xdd, "array") }
+
+// bytes reads a str or bin value without copying.
+func (r *msgpackReader) bytes() []byte {
+	var n int
+	switch t := r.byte(); {
+	case r.err != nil:
+		return nil
+	case t&0xe0 == 0xa0:
+		n = int(t & 0x1f)
+	case t == 0xd9, t == 0xc4:
+		n = int(r.uint(1))
+	case t == 0xda, t == 0xc5:
+		n = int(r.uint(2))
+	case t == 0xdb, t == 0xc6:
+		n = int(r.uint(4))
+	case t == 0xc0:
+		return nil
+	default:
+		r.fail("expected string, got type 0x%02x", t)
+		return nil
+	}
+	return r.next(n)
+}
+
+func (r *msgpackReader) int() int64 {
+	switch t := r.byte(); {
+	case r.err != nil:
+	case t <= 0x7f:
+		return int64(t)
+	case t >= 0xe0:
+		return int64(int8(t))
+	case t == 0xcc:
+		return int64(r.uint(1))
+	case t == 0xcd:
+		return int64(r.uint(2))
+	case t == 0xce:
+		return int64(r.uint(4))
+	case t == 0xcf:
+		v := r.uint(8)
+		if v > math.MaxInt64 {
+			r.fail("uint64 %d overflows int64", v)
+		}
+		return int64(v)
+	case t == 0xd0:
+		return int64(int8(r.uint(1)))
+	case t == 0xd1:
+		return int64(int16(r.uint(2)))
+	case t == 0xd2:
+		return int64(int32(r.uint(4)))
+	case t == 0xd3:
+		return int64(r.uint(8))
+	case t == 0xc0:
+		return 0
+	default:
+		r.fail("expected integer, got type 0x%02x", t)
+	}
+	return 0
+}
+
+func (r *msgpackReader) int32(what string) int32 {
+	v := r.int()
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		r.fail("%s: %d overflows int32", what, v)
+		return 0
+	}
+	return int32(v)
+}
+
+// float reads a float of either width, or an integer.
+func (r *msgpackReader) float() float64 {
+	if len(r.data) == 0 {
+		r.fail("truncated")
+		return 0
+	}
+	switch r.data[0] {
+	case 0xca:
+		r.data = r.data[1:]
+		return float64(math.Float32frombits(uint32(r.uint(4))))
+	case 0xcb:
+		r.data = r.data[1:]
+		return math.Float64frombits(r.uint(8))
+	}
+	return float64(r.int())
+}
+
+func (r *msgpackReader) bool() bool {
+	switch t := r.byte(); {
+	case r.err != nil:
+	case t == 0xc3:
+		return true
+	case t == 0xc2, t == 0xc0:
+		return false
+	default:
+		r.fail("expected bool, got type 0x%02x", t)
+	}
+	return false
+}
+
+// skip consumes one value of any type.
+func (r *msgpackReader) skip(depth int) {
+	if depth > msgpackMaxDepth {
+		r.fail("nesting deeper than %d", msgpackMaxDepth)
+		return
+	}
+	if len(r.data) == 0 {
+		r.fail("truncated")
+		return
+	}
+	switch t := r.data[0]; {
+	case t <= 0x7f, t >= 0xe0, t == 0xc0, t == 0xc2, t == 0xc3:
+		r.next(1)
+	case t&0xf0 == 0x80, t == 0xde, t == 0xdf:
+		for n := 2 * r.mapLen(); n > 0 && r.err == nil; n-- {
+			r.skip(depth + 1)
+		}
+	case t&0xf0 == 0x90, t == 0xdc, t == 0xdd:
+		for n := r.arrayLen(); n > 0 && r.err == nil; n-- {
+			r.skip(depth + 1)
+		}
+	case t&0xe0 == 0xa0, t == 0xd9, t == 0xda, t == 0xdb, t == 0xc4, t == 0xc5, t == 0xc6:
+		r.bytes()
+	case t == 0xcc, t == 0xd0:
+		r.next(2)
+	case t == 0xcd, t == 0xd1:
+		r.next(3)
+	case t == 0xce, t == 0xd2, t == 0xca:
+		r.next(5)
+	case t == 0xcf, t == 0xd3, t == 0xcb:
+		r.next(9)
+	case t >= 0xd4 && t <= 0xd8: // fixext 1, 2, 4, 8, 16
+		r.next(2 + 1<<(t-0xd4))
+	case t == 0xc7, t == 0xc8, t == 0xc9: // ext 8, 16, 32
+		r.next(1)
+		n := int(r.uint(1 << (t - 0xc7)))
+		r.next(1 + n)
+	default:
+		r.fail("unknown type 0x%02x", t)
+	}
+}
+
+// decodeField reads the value of userFields[i] into user.
+func (r *msgpackReader) decodeField(i int, user *UserInfo) {
+	switch userFields[i].name {
+	case "id":
+		user.ID = r.int()
+	case "username":
+		user.Username = string(r.bytes())
+	case "email":
+		user.Email = string(r.bytes())
+	case "first_name":
+		user.FirstName = string(r.bytes())
+	case "last_name":
+		user.LastName = string(r.bytes())
+	case "age":
+		user.Age = r.int32("age")
+	case "height":
+		user.Height = float32(r.float())
+	case "weight":
+		user.Weight = float32(r.float())
+	case "balance":
+		user.Balance = r.float()
+	case "is_active":
+		user.IsActive = r.bool()
+	case "created_at":
+		user.CreatedAt = r.int()
+	case "updated_at":
+		user.UpdatedAt = r.int()
+	case "login_count":
+		user.LoginCount = r.int32("login_count")
+	case "score":
+		user.Score = r.float()
+	case "description":
+		user.Description = string(r.bytes())
+	}
+}
+
+func (s *MsgPackStrategy) decodeMsgpack(data []byte) (*UserInfo, error) {
+	r := &msgpackReader{data: data}
+	user := &UserInfo{}
+	if s.ArrayMode {
+		n := r.arrayLen()
+		for i := 0; i < n && r.err == nil; i++ {
+			if i < len(userFields) {
+				r.decodeField(i, user)
+			} else {
+				r.skip(0)
+			}
+		}
+	} else {
+		for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+			key := r.bytes()
+			if i, ok := lookupUserField(string(key)); ok {
+				r.decodeField(i, user)
+			} else {
+				r.skip(0)
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(r.data))
+	}
+	return user, nil
+}
+
+// fieldNumber reads the named numeric field of a record, skipping over the
+// values before it without decoding them.
+func (s *MsgPackStrategy) fieldNumber(data []byte, fieldName string) (float64, error) {
+	r := &msgpackReader{data: data}
+	if s.ArrayMode {
+		idx, _ := lookupUserField(fieldName)
+		n := r.arrayLen()
+		for i := 0; i < n && r.err == nil; i++ {
+			if i == idx {
+				return r.float(), r.err
+			}
+			r.skip(0)
+		}
+	} else {
+		for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+			if string(r.bytes()) == fieldName {
+				return r.float(), r.err
+			}
+			r.skip(0)
+		}
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, nil
+}
+
+func (s *MsgPackStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, s.encodeMsgpack(user))
+	})
+}
+
+func (s *MsgPackStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		for _, user := range users {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, s.encodeMsgpack(user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *MsgPackStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeMsgpack(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *MsgPackStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket()).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeMsgpack(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *MsgPackStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket())
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		user, err := s.decodeMsgpack(data)
+		if err != nil {
+			return err
+		}
+		if err := setUserField(user, fieldName, value); err != nil {
+			return err
+		}
+		return b.Put(key, s.encodeMsgpack(user))
+	})
+}
+
+func (s *MsgPackStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucket()).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			n, err := s.fieldNumber(v, fieldName)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *MsgPackStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeMsgpack(user), nil
+}
+
+func (s *MsgPackStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeMsgpack(data)
+}
+
+func (s *MsgPackStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, s.bucket(), corrupt)
+}
@@ -1,21 +1,62 @@
 package strategy
 
 import (
+	"boltdb_benchmarks/strategy/fieldcodec"
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"go.etcd.io/bbolt"
-	"strconv"
 )
 
 // 5. Multiple KV pairs strategy
+//
+// One KV pair per field, keyed by the 8-byte ID followed by the field name,
+// with the value in the fieldcodec encoding of the field. The encoding
+// version is kept in users_multikv_meta; Setup rejects data in the legacy
+// encoding, which Migrate converts.
 type MultiKVStrategy struct{}
 
 func (s *MultiKVStrategy) Name() string { return "MultiKV" }
 
 func (s *MultiKVStrategy) Setup(db *bbolt.DB) error {
 	return db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("users_multikv"))
-		return err
+		b, err := tx.CreateBucketIfNotExists([]byte("users_multikv"))
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte("users_multikv_meta"))
+		if err != nil {
+			return err
+		}
+		return fieldcodec.CheckVersion(meta, b)
+	})
+}
+
+// Migrate converts a database written with the legacy field encoding to
+// the current one. Floats keep only their integer part, which is all the
+// legacy encoding stored.
+func (s *MultiKVStrategy) Migrate(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("users_multikv"))
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte("users_multikv_meta"))
+		if err != nil {
+			return err
+		}
+		version, err := fieldcodec.ReadVersion(meta, b)
+		if err != nil || version == fieldcodec.Version {
+			return err
+		}
+		if version != fieldcodec.LegacyVersion {
+			return fmt.Errorf("migrate: unsupported format version %d", version)
+		}
+		err = migrateLegacyFields(b, func(k []byte) string { return string(k[8:]) })
+		if err != nil {
+			return err
+		}
+		return fieldcodec.SetVersion(meta, fieldcodec.Version)
 	})
 }
 
@@ -25,110 +66,14 @@ func (s *MultiKVStrategy) makeKey(id int64, field string) []byte {
 	return append(idBytes, []byte(field)...)
 }
 
-func (s *MultiKVStrategy) decodeField(user *UserInfo, field string, data []byte) {
-	switch field {
-	case "id":
-		user.ID, _ = strconv.ParseInt(string(data), 10, 64)
-	case "username":
-		user.Username = string(data)
-	case "email":
-		user.Email = string(data)
-	case "first_name":
-		user.FirstName = string(data)
-	case "last_name":
-		user.LastName = string(data)
-	case "age":
-		age, _ := strconv.ParseInt(string(data), 10, 32)
-		user.Age = int32(age)
-	case "height":
-		user.Height = float32(binary.LittleEndian.Uint32(data))
-	case "weight":
-		user.Weight = float32(binary.LittleEndian.Uint32(data))
-	case "balance":
-		user.Balance = float64(binary.LittleEndian.Uint64(data))
-	case "is_active":
-		user.IsActive = string(data) == "true"
-	case "created_at":
-		user.CreatedAt, _ = strconv.ParseInt(string(data), 10, 64)
-	case "updated_at":
-		user.UpdatedAt, _ = strconv.ParseInt(string(data), 10, 64)
-	case "login_count":
-		cnt, _ := strconv.ParseInt(string(data), 10, 32)
-		user.LoginCount = int32(cnt)
-	case "score":
-		user.Score = float64(binary.LittleEndian.Uint64(data))
-	case "description":
-		user.Description = string(data)
-	}
-}
-
 func (s *MultiKVStrategy) writeUserFields(b *bbolt.Bucket, user *UserInfo) error {
 	// Store each field as a separate KV pair
-	if err := b.Put(s.makeKey(user.ID, "id"), []byte(strconv.FormatInt(user.ID, 10))); err != nil {
-		return err
-	}
-	if err := b.Put(s.makeKey(user.ID, "username"), []byte(user.Username)); err != nil {
-		return err
-	}
-	if err := b.Put(s.makeKey(user.ID, "email"), []byte(user.Email)); err != nil {
-		return err
-	}
-	if err := b.Put(s.makeKey(user.ID, "first_name"), []byte(user.FirstName)); err != nil {
-		return err
-	}
-	if err := b.Put(s.makeKey(user.ID, "last_name"), []byte(user.LastName)); err != nil {
-		return err
-	}
-	if err := b.Put(s.makeKey(user.ID, "age"), []byte(strconv.FormatInt(int64(user.Age), 10))); err != nil {
-		return err
-	}
-
-	heightBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(heightBytes, uint32(user.Height))
-	if err := b.Put(s.makeKey(user.ID, "height"), heightBytes); err != nil {
-		return err
-	}
-
-	weightBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(weightBytes, uint32(user.Weight))
-	if err := b.Put(s.makeKey(user.ID, "weight"), weightBytes); err != nil {
-		return err
-	}
-
-	balanceBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(balanceBytes, uint64(user.Balance))
-	if err := b.Put(s.makeKey(user.ID, "balance"), balanceBytes); err != nil {
-		return err
-	}
-
-	activeBytes := []byte("false")
-	if user.IsActive {
-		activeBytes = []byte("true")
-	}
-	if err := b.Put(s.makeKey(user.ID, "is_active"), activeBytes); err != nil {
-		return err
-	}
-
-	if err := b.Put(s.makeKey(user.ID, "created_at"), []byte(strconv.FormatInt(user.CreatedAt, 10))); err != nil {
-		return err
-	}
-	if err := b.Put(s.makeKey(user.ID, "updated_at"), []byte(strconv.FormatInt(user.UpdatedAt, 10))); err != nil {
-		return err
-	}
-	if err := b.Put(s.makeKey(user.ID, "login_count"), []byte(strconv.FormatInt(int64(user.LoginCount), 10))); err != nil {
-		return err
-	}
-
-	scoreBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(scoreBytes, uint64(user.Score))
-	if err := b.Put(s.makeKey(user.ID, "score"), scoreBytes); err != nil {
-		return err
-	}
-
-	if err := b.Put(s.makeKey(user.ID, "description"), []byte(user.Description)); err != nil {
-		return err
+	for i := range userFields {
+		f := &userFields[i]
+		if err := b.Put(s.makeKey(user.ID, f.name), f.appendValue(nil, user)); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
@@ -160,9 +105,15 @@ func (s *MultiKVStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
 		// seek to the first key for this id
 		prefix := make([]byte, 8)
 		binary.BigEndian.PutUint64(prefix, uint64(id))
+		found := false
 		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
-			field := string(k[8:])
-			s.decodeField(user, field, v)
+			if err := decodeNamedField(user, string(k[8:]), v); err != nil {
+				return err
+			}
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("user %d not found", id)
 		}
 		return nil
 	})
@@ -189,7 +140,7 @@ func (s *MultiKVStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*U
 			if id < startId {
 				continue
 			}
-			if id != currentId {
+			if currentUser == nil || id != currentId {
 				if currentUser != nil {
 					users = append(users, currentUser)
 					if len(users) >= count {
@@ -199,8 +150,9 @@ func (s *MultiKVStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*U
 				currentId = id
 				currentUser = &UserInfo{}
 			}
-			field := string(k[8:])
-			s.decodeField(currentUser, field, v)
+			if err := decodeNamedField(currentUser, string(k[8:]), v); err != nil {
+				return err
+			}
 		}
 		if currentUser != nil && len(users) < count {
 			users = append(users, currentUser)
@@ -211,57 +163,53 @@ func (s *MultiKVStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*U
 }
 
 func (s *MultiKVStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	i, ok := lookupUserField(fieldName)
+	if !ok {
+		return fmt.Errorf("field %q is not updatable", fieldName)
+	}
+	var patch UserInfo
+	if err := setUserField(&patch, fieldName, value); err != nil {
+		return err
+	}
 	return db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("users_multikv"))
-
-		switch fieldName {
-		case "balance":
-			balanceBytes := make([]byte, 8)
-			binary.LittleEndian.PutUint64(balanceBytes, uint64(value.(float64)))
-			return b.Put(s.makeKey(id, "balance"), balanceBytes)
-		case "login_count":
-			return b.Put(s.makeKey(id, "login_count"), []byte(strconv.FormatInt(int64(value.(int32)), 10)))
-		case "score":
-			scoreBytes := make([]byte, 8)
-			binary.LittleEndian.PutUint64(scoreBytes, uint64(value.(float64)))
-			return b.Put(s.makeKey(id, "score"), scoreBytes)
+		prefix := s.makeKey(id, "")
+		if k, _ := b.Cursor().Seek(prefix); k == nil || !bytes.HasPrefix(k, prefix) {
+			return fmt.Errorf("user %d not found", id)
 		}
-		return nil
+		return b.Put(s.makeKey(id, fieldName), userFields[i].appendValue(nil, &patch))
 	})
 }
 
 func (s *MultiKVStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
 	var sum float64
 	err := db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("users_multikv"))
 		c := b.Cursor()
 
-		// Look for keys with the specific field suffix
-		fieldSuffix := []byte(fieldName)
+		// Look for the keys of the field
 		processed := 0
-		seenIDs := make(map[int64]bool)
+		var user UserInfo
 
 		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
-			if len(k) >= len(fieldSuffix) && bytes.Equal(k[len(k)-len(fieldSuffix):], fieldSuffix) {
-				// Extract ID from key
-				idBytes := k[:8]
-				id := int64(binary.BigEndian.Uint64(idBytes))
-
-				if !seenIDs[id] {
-					seenIDs[id] = true
-					processed++
-
-					switch fieldName {
-					case "balance", "score":
-						sum += float64(binary.LittleEndian.Uint64(v))
-					case "login_count":
-						count, _ := strconv.ParseInt(string(v), 10, 32)
-						sum += float64(count)
-					}
-				}
+			if len(k) < 8 || string(k[8:]) != fieldName {
+				continue
 			}
+			if err := decodeNamedField(&user, fieldName, v); err != nil {
+				return err
+			}
+			n, _ := userFieldNumber(&user, fieldName)
+			sum += n
+			processed++
 		}
 		return nil
 	})
 	return sum, err
 }
+
+func (s *MultiKVStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyFieldsByRead[UserInfo](db, s, []byte("users_multikv"), []byte("users_multikv_meta"), corrupt)
+}
@@ -0,0 +1,305 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"sync"
+)
+
+// 23. MultiKV strategy with field IDs
+//
+// Like MultiKVStrategy, one KV pair per field, but the key is the 8-byte ID
+// followed by a one-byte field ID instead of the field name, and every value
+// uses the same binary encoding: fixed-width little-endian numbers, one
+// byte for bools and raw bytes for strings.
+//
+// Field IDs are assigned on first use and persisted in a registry bucket
+// (field name → ID), so a database keeps its IDs when fields are added,
+// reordered or removed. Values of fields the registry knows but UserInfo
+// no longer has are skipped on read.
+type MultiKVIDStrategy struct {
+	mu         sync.RWMutex
+	registries map[*bbolt.DB]*fieldRegistry
+}
+
+// fieldRegistry maps userFields to their persisted one-byte IDs.
+type fieldRegistry struct {
+	ids    []byte   // by userFields index
+	fields [256]int // by ID: userFields index, -1 for a removed field, -2 if unassigned
+}
+
+func (s *MultiKVIDStrategy) Name() string { return "MultiKV(ids)" }
+
+func (s *MultiKVIDStrategy) Setup(db *bbolt.DB) error {
+	var reg *fieldRegistry
+	err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("users_multikv_ids")); err != nil {
+			return err
+		}
+		fields, err := tx.CreateBucketIfNotExists([]byte("users_multikv_fields"))
+		if err != nil {
+			return err
+		}
+		if reg, err = loadFieldRegistry(fields); err != nil {
+			return err
+		}
+		for i := range userFields {
+			if reg.ids[i] != 0 {
+				continue
+			}
+			seq, err := fields.NextSequence()
+			if err != nil {
+				return err
+			}
+			if seq > 255 {
+				return fmt.Errorf("multikv: out of field ids")
+			}
+			id := byte(seq)
+			if reg.fields[id] != -2 {
+				return fmt.Errorf("multikv: field id %d is already taken", id)
+			}
+			if err := fields.Put([]byte(userFields[i].name), []byte{id}); err != nil {
+				return err
+			}
+			reg.ids[i], reg.fields[id] = id, i
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.setRegistry(db, reg)
+	return nil
+}
+
+// loadFieldRegistry reads the field IDs persisted in fields. Fields that
+// have no ID yet keep ID 0.
+func loadFieldRegistry(fields *bbolt.Bucket) (*fieldRegistry, error) {
+	reg := &fieldRegistry{ids: make([]byte, len(userFields))}
+	for i := range reg.fields {
+		reg.fields[i] = -2
+	}
+	err := fields.ForEach(func(k, v []byte) error {
+		if len(v) != 1 || v[0] == 0 {
+			return fmt.Errorf("multikv: bad id for field %q", k)
+		}
+		reg.fields[v[0]] = -1
+		if i, ok := lookupUserField(string(k)); ok {
+			reg.ids[i], reg.fields[v[0]] = v[0], i
+		}
+		return nil
+	})
+	return reg, err
+}
+
+func (s *MultiKVIDStrategy) setRegistry(db *bbolt.DB, reg *fieldRegistry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.registries == nil {
+		s.registries = make(map[*bbolt.DB]*fieldRegistry)
+	}
+	s.registries[db] = reg
+}
+
+func (s *MultiKVIDStrategy) registry(db *bbolt.DB) (*fieldRegistry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reg, ok := s.registries[db]
+	if !ok {
+		return nil, fmt.Errorf("multikv: no field registry, Setup was not called")
+	}
+	return reg, nil
+}
+
+func multiKVIDKey(id int64, fieldID byte) []byte {
+	return append(binary.BigEndian.AppendUint64(make([]byte, 0, 9), uint64(id)), fieldID)
+}
+
+// decodeField decodes the value of the KV pair k into user.
+func (r *fieldRegistry) decodeField(user *UserInfo, k, v []byte) error {
+	if len(k) != 9 {
+		return fmt.Errorf("multikv: bad key length %d", len(k))
+	}
+	switch i := r.fields[k[8]]; i {
+	case -1:
+		return nil
+	case -2:
+		return fmt.Errorf("multikv: unknown field id %d", k[8])
+	default:
+		return userFields[i].decodeValue(v, user)
+	}
+}
+
+func (s *MultiKVIDStrategy) put(b *bbolt.Bucket, reg *fieldRegistry, user *UserInfo) error {
+	// bbolt keeps the value slices until commit, so each gets its own.
+	for i := range userFields {
+		if err := b.Put(multiKVIDKey(user.ID, reg.ids[i]), userFields[i].appendValue(nil, user)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MultiKVIDStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	reg, err := s.registry(db)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		return s.put(tx.Bucket([]byte("users_multikv_ids")), reg, user)
+	})
+}
+
+func (s *MultiKVIDStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	reg, err := s.registry(db)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_multikv_ids"))
+		for _, user := range users {
+			if err := s.put(b, reg, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *MultiKVIDStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	reg, err := s.registry(db)
+	if err != nil {
+		return nil, err
+	}
+	var user *UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_multikv_ids")).Cursor()
+		prefix := idKey(id)
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if user == nil {
+				user = &UserInfo{}
+			}
+			if err := reg.decodeField(user, k, v); err != nil {
+				return err
+			}
+		}
+		if user == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (s *MultiKVIDStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	reg, err := s.registry(db)
+	if err != nil {
+		return nil, err
+	}
+	var users []*UserInfo
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_multikv_ids")).Cursor()
+
+		var currentID []byte
+		var user *UserInfo
+		for k, v := c.Seek(idKey(startId)); k != nil; k, v = c.Next() {
+			if len(k) != 9 {
+				return fmt.Errorf("multikv: bad key length %d", len(k))
+			}
+			if user == nil || !bytes.Equal(k[:8], currentID) {
+				if len(users) == count {
+					break
+				}
+				currentID = k[:8]
+				user = &UserInfo{}
+				users = append(users, user)
+			}
+			if err := reg.decodeField(user, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *MultiKVIDStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	reg, err := s.registry(db)
+	if err != nil {
+		return err
+	}
+	i, ok := lookupUserField(fieldName)
+	if !ok {
+		return fmt.Errorf("field %q is not updatable", fieldName)
+	}
+	var patch UserInfo
+	if err := setUserField(&patch, fieldName, value); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_multikv_ids"))
+		key := multiKVIDKey(id, reg.ids[i])
+		if b.Get(key) == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		return b.Put(key, userFields[i].appendValue(nil, &patch))
+	})
+}
+
+func (s *MultiKVIDStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	reg, err := s.registry(db)
+	if err != nil {
+		return 0, err
+	}
+	i, _ := lookupUserField(fieldName)
+	fieldID := reg.ids[i]
+
+	var sum float64
+	err = db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_multikv_ids")).Cursor()
+		processed := 0
+		var user UserInfo
+
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			if len(k) != 9 || k[8] != fieldID {
+				continue
+			}
+			if err := userFields[i].decodeValue(v, &user); err != nil {
+				return err
+			}
+			n, _ := userFieldNumber(&user, fieldName)
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+// Verify loads the field registry without assigning IDs to new fields, so
+// that it can check a database opened read-only.
+func (s *MultiKVIDStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	if _, err := s.registry(db); err != nil {
+		err := db.View(func(tx *bbolt.Tx) error {
+			fields := tx.Bucket([]byte("users_multikv_fields"))
+			if fields == nil {
+				return fmt.Errorf("multikv: bucket users_multikv_fields not found")
+			}
+			reg, err := loadFieldRegistry(fields)
+			if err != nil {
+				return err
+			}
+			s.setRegistry(db, reg)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return verifyByRead[UserInfo](db, s, []byte("users_multikv_ids"), corrupt)
+}
@@ -1,21 +1,63 @@
 package strategy
 
 import (
+	"boltdb_benchmarks/strategy/fieldcodec"
 	"encoding/binary"
 	"fmt"
 	"go.etcd.io/bbolt"
-	"strconv"
 )
 
 // 6. Nested bucket strategy
+//
+// One bucket per record, named by the 8-byte ID, with one key per field and
+// the value in the fieldcodec encoding of the field. The encoding version
+// is kept in users_nested_meta; Setup rejects data in the legacy encoding,
+// which Migrate converts.
 type NestedBucketStrategy struct{}
 
 func (s *NestedBucketStrategy) Name() string { return "NestedBucket" }
 
 func (s *NestedBucketStrategy) Setup(db *bbolt.DB) error {
 	return db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("users_nested"))
-		return err
+		root, err := tx.CreateBucketIfNotExists([]byte("users_nested"))
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte("users_nested_meta"))
+		if err != nil {
+			return err
+		}
+		return fieldcodec.CheckVersion(meta, root)
+	})
+}
+
+// Migrate converts a database written with the legacy field encoding to
+// the current one. Floats keep only their integer part, which is all the
+// legacy encoding stored.
+func (s *NestedBucketStrategy) Migrate(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte("users_nested"))
+		if err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte("users_nested_meta"))
+		if err != nil {
+			return err
+		}
+		version, err := fieldcodec.ReadVersion(meta, root)
+		if err != nil || version == fieldcodec.Version {
+			return err
+		}
+		if version != fieldcodec.LegacyVersion {
+			return fmt.Errorf("migrate: unsupported format version %d", version)
+		}
+		err = root.ForEachBucket(func(k []byte) error {
+			return migrateLegacyFields(root.Bucket(k), func(k []byte) string { return string(k) })
+		})
+		if err != nil {
+			return err
+		}
+		return fieldcodec.SetVersion(meta, fieldcodec.Version)
 	})
 }
 
@@ -29,41 +71,12 @@ func (s *NestedBucketStrategy) writeUserFields(rootBucket *bbolt.Bucket, user *U
 	}
 
 	// Store each field in the user's bucket
-	userBucket.Put([]byte("id"), []byte(strconv.FormatInt(user.ID, 10)))
-	userBucket.Put([]byte("username"), []byte(user.Username))
-	userBucket.Put([]byte("email"), []byte(user.Email))
-	userBucket.Put([]byte("first_name"), []byte(user.FirstName))
-	userBucket.Put([]byte("last_name"), []byte(user.LastName))
-	userBucket.Put([]byte("age"), []byte(strconv.FormatInt(int64(user.Age), 10)))
-
-	heightBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(heightBytes, uint32(user.Height))
-	userBucket.Put([]byte("height"), heightBytes)
-
-	weightBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(weightBytes, uint32(user.Weight))
-	userBucket.Put([]byte("weight"), weightBytes)
-
-	balanceBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(balanceBytes, uint64(user.Balance))
-	userBucket.Put([]byte("balance"), balanceBytes)
-
-	activeBytes := []byte("false")
-	if user.IsActive {
-		activeBytes = []byte("true")
+	for i := range userFields {
+		f := &userFields[i]
+		if err := userBucket.Put([]byte(f.name), f.appendValue(nil, user)); err != nil {
+			return err
+		}
 	}
-	userBucket.Put([]byte("is_active"), activeBytes)
-
-	userBucket.Put([]byte("created_at"), []byte(strconv.FormatInt(user.CreatedAt, 10)))
-	userBucket.Put([]byte("updated_at"), []byte(strconv.FormatInt(user.UpdatedAt, 10)))
-	userBucket.Put([]byte("login_count"), []byte(strconv.FormatInt(int64(user.LoginCount), 10)))
-
-	scoreBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(scoreBytes, uint64(user.Score))
-	userBucket.Put([]byte("score"), scoreBytes)
-
-	userBucket.Put([]byte("description"), []byte(user.Description))
-
 	return nil
 }
 
@@ -86,43 +99,6 @@ func (s *NestedBucketStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error
 	})
 }
 
-func (s *NestedBucketStrategy) decodeField(user *UserInfo, field string, data []byte) {
-	switch field {
-	case "id":
-		user.ID, _ = strconv.ParseInt(string(data), 10, 64)
-	case "username":
-		user.Username = string(data)
-	case "email":
-		user.Email = string(data)
-	case "first_name":
-		user.FirstName = string(data)
-	case "last_name":
-		user.LastName = string(data)
-	case "age":
-		age, _ := strconv.ParseInt(string(data), 10, 32)
-		user.Age = int32(age)
-	case "height":
-		user.Height = float32(binary.LittleEndian.Uint32(data))
-	case "weight":
-		user.Weight = float32(binary.LittleEndian.Uint32(data))
-	case "balance":
-		user.Balance = float64(binary.LittleEndian.Uint64(data))
-	case "is_active":
-		user.IsActive = string(data) == "true"
-	case "created_at":
-		user.CreatedAt, _ = strconv.ParseInt(string(data), 10, 64)
-	case "updated_at":
-		user.UpdatedAt, _ = strconv.ParseInt(string(data), 10, 64)
-	case "login_count":
-		cnt, _ := strconv.ParseInt(string(data), 10, 32)
-		user.LoginCount = int32(cnt)
-	case "score":
-		user.Score = float64(binary.LittleEndian.Uint64(data))
-	case "description":
-		user.Description = string(data)
-	}
-}
-
 func (s *NestedBucketStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
 	user := &UserInfo{}
 	err := db.View(func(tx *bbolt.Tx) error {
@@ -132,12 +108,14 @@ func (s *NestedBucketStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
 
 		userBucket := root.Bucket(key)
 		if userBucket == nil {
-			return fmt.Errorf("user not found")
+			return fmt.Errorf("user %d not found", id)
 		}
 
 		c := userBucket.Cursor()
 		for fk, fv := c.First(); fk != nil; fk, fv = c.Next() {
-			s.decodeField(user, string(fk), fv)
+			if err := decodeNamedField(user, string(fk), fv); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -163,7 +141,9 @@ func (s *NestedBucketStrategy) ReadMany(db *bbolt.DB, startId int64, count int)
 			user := &UserInfo{}
 			uc := userBucket.Cursor()
 			for fk, fv := uc.First(); fk != nil; fk, fv = uc.Next() {
-				s.decodeField(user, string(fk), fv)
+				if err := decodeNamedField(user, string(fk), fv); err != nil {
+					return err
+				}
 			}
 			users = append(users, user)
 		}
@@ -173,6 +153,14 @@ func (s *NestedBucketStrategy) ReadMany(db *bbolt.DB, startId int64, count int)
 }
 
 func (s *NestedBucketStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	i, ok := lookupUserField(fieldName)
+	if !ok {
+		return fmt.Errorf("field %q is not updatable", fieldName)
+	}
+	var patch UserInfo
+	if err := setUserField(&patch, fieldName, value); err != nil {
+		return err
+	}
 	return db.Update(func(tx *bbolt.Tx) error {
 		rootBucket := tx.Bucket([]byte("users_nested"))
 		userKey := make([]byte, 8)
@@ -180,43 +168,32 @@ func (s *NestedBucketStrategy) UpdateField(db *bbolt.DB, id int64, fieldName str
 
 		userBucket := rootBucket.Bucket(userKey)
 		if userBucket == nil {
-			return fmt.Errorf("user not found")
-		}
-
-		switch fieldName {
-		case "balance":
-			balanceBytes := make([]byte, 8)
-			binary.LittleEndian.PutUint64(balanceBytes, uint64(value.(float64)))
-			return userBucket.Put([]byte("balance"), balanceBytes)
-		case "login_count":
-			return userBucket.Put([]byte("login_count"), []byte(strconv.FormatInt(int64(value.(int32)), 10)))
-		case "score":
-			scoreBytes := make([]byte, 8)
-			binary.LittleEndian.PutUint64(scoreBytes, uint64(value.(float64)))
-			return userBucket.Put([]byte("score"), scoreBytes)
+			return fmt.Errorf("user %d not found", id)
 		}
-		return nil
+		return userBucket.Put([]byte(fieldName), userFields[i].appendValue(nil, &patch))
 	})
 }
 
 func (s *NestedBucketStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
 	var sum float64
 	err := db.View(func(tx *bbolt.Tx) error {
 		rootBucket := tx.Bucket([]byte("users_nested"))
 		c := rootBucket.Cursor()
 		processed := 0
+		var user UserInfo
 
 		for k, _ := c.First(); k != nil && processed < count; k, _ = c.Next() {
 			userBucket := rootBucket.Bucket(k)
 			if userBucket != nil {
 				if data := userBucket.Get([]byte(fieldName)); data != nil {
-					switch fieldName {
-					case "balance", "score":
-						sum += float64(binary.LittleEndian.Uint64(data))
-					case "login_count":
-						count, _ := strconv.ParseInt(string(data), 10, 32)
-						sum += float64(count)
+					if err := decodeNamedField(&user, fieldName, data); err != nil {
+						return err
 					}
+					n, _ := userFieldNumber(&user, fieldName)
+					sum += n
 				}
 				processed++
 			}
@@ -225,3 +202,7 @@ func (s *NestedBucketStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, coun
 	})
 	return sum, err
 }
+
+func (s *NestedBucketStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyFieldsByRead[UserInfo](db, s, []byte("users_nested"), []byte("users_nested_meta"), corrupt)
+}
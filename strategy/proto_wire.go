@@ -0,0 +1,477 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+)
+
+// 17. Protocol Buffers wire-format strategy
+//
+// Stores each record as the protobuf encoding of the message below, written
+// by hand against protoFields rather than generated by protoc:
+//
+//	message UserInfo {
+//	  sint64 id = 1;           string username = 2;    string email = 3;
+//	  string first_name = 4;   string last_name = 5;   sint32 age = 6;
+//	  float height = 7;        float weight = 8;       double balance = 9;
+//	  bool is_active = 10;     sint64 created_at = 11; sint64 updated_at = 12;
+//	  sint32 login_count = 13; double score = 14;      string description = 15;
+//	}
+//
+// As in proto3, fields holding their zero value are omitted. Decoding skips
+// unknown fields, including deprecated groups (wire types 3 and 4), and
+// lets the last occurrence of a repeated field win.
+type ProtoWireStrategy struct{}
+
+const (
+	protoVarint     = 0
+	protoFixed64    = 1
+	protoBytes      = 2
+	protoStartGroup = 3
+	protoEndGroup   = 4
+	protoFixed32    = 5
+)
+
+// protoFields gives the field number and wire type of each userFields entry.
+var protoFields = []struct {
+	number uint64
+	wire   byte
+}{
+	{1, protoVarint},   // id
+	{2, protoBytes},    // username
+	{3, protoBytes},    // email
+	{4, protoBytes},    // first_name
+	{5, protoBytes},    // last_name
+	{6, protoVarint},   // age
+	{7, protoFixed32},  // height
+	{8, protoFixed32},  // weight
+	{9, protoFixed64},  // balance
+	{10, protoVarint},  // is_active
+	{11, protoVarint},  // created_at
+	{12, protoVarint},  // updated_at
+	{13, protoVarint},  // login_count
+	{14, protoFixed64}, // score
+	{15, protoBytes},   // description
+}
+
+func (s *ProtoWireStrategy) Name() string { return "ProtoWire" }
+
+func (s *ProtoWireStrategy) Setup(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("users_proto"))
+		return err
+	})
+}
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
+
+func unzigzag(v uint64) int64 { return int64(v>>1) ^ -int64(v&1) }
+
+// appendProtoField writes the tag and value of userFields[i], or nothing if
+// the value is zero.
+func appendProtoField(buf []byte, i int, user *UserInfo) []byte {
+	f := protoFields[i]
+	tag := f.number<<3 | uint64(f.wire)
+	varint := func(v uint64) []byte {
+		if v == 0 {
+			return buf
+		}
+		return binary.AppendUvarint(binary.AppendUvarint(buf, tag), v)
+	}
+	str := func(v string) []byte {
+		if v == "" {
+			return buf
+		}
+		buf = binary.AppendUvarint(binary.AppendUvarint(buf, tag), uint64(len(v)))
+		return append(buf, v...)
+	}
+	fixed32 := func(v float32) []byte {
+		if math.Float32bits(v) == 0 {
+			return buf
+		}
+		return binary.LittleEndian.AppendUint32(binary.AppendUvarint(buf, tag), math.Float32bits(v))
+	}
+	fixed64 := func(v float64) []byte {
+		if math.Float64bits(v) == 0 {
+			return buf
+		}
+		return binary.LittleEndian.AppendUint64(binary.AppendUvarint(buf, tag), math.Float64bits(v))
+	}
+
+	switch userFields[i].name {
+	case "id":
+		return varint(zigzag(user.ID))
+	case "username":
+		return str(user.Username)
+	case "email":
+		return str(user.Email)
+	case "first_name":
+		return str(user.FirstName)
+	case "last_name":
+		return str(user.LastName)
+	case "age":
+		return varint(zigzag(int64(user.Age)))
+	case "height":
+		return fixed32(user.Height)
+	case "weight":
+		return fixed32(user.Weight)
+	case "balance":
+		return fixed64(user.Balance)
+	case "is_active":
+		if user.IsActive {
+			return varint(1)
+		}
+		return buf
+	case "created_at":
+		return varint(zigzag(user.CreatedAt))
+	case "updated_at":
+		return varint(zigzag(user.UpdatedAt))
+	case "login_count":
+		return varint(zigzag(int64(user.LoginCount)))
+	case "score":
+		return fixed64(user.Score)
+	case "description":
+		return str(user.Description)
+	}
+	panic("proto: unhandled field " + userFields[i].name)
+}
+
+func (s *ProtoWireStrategy) encodeProto(user *UserInfo) []byte {
+	buf := make([]byte, 0, 96+len(user.Username)+len(user.Email)+
+		len(user.FirstName)+len(user.LastName)+len(user.Description))
+	for i := range userFields {
+		buf = appendProtoField(buf, i, user)
+	}
+	return buf
+}
+
+// protoValue is one field of an encoded message. For varints and fixed
+// types bits holds the value; for length-delimited fields data does.
+type protoValue struct {
+	number uint64
+	wire   byte
+	bits   uint64
+	data   []byte
+}
+
+// protoMaxDepth bounds the nesting of groups the decoder will skip.
+const protoMaxDepth = 32
+
+// nextProtoField parses the field at the start of data and returns it with
+// its encoded length.
+func nextProtoField(data []byte) (protoValue, int, error) {
+	return protoField(data, 0)
+}
+
+// protoField is nextProtoField for a field nested in depth groups.
+func protoField(data []byte, depth int) (protoValue, int, error) {
+	tag, n := binary.Uvarint(data)
+	if n <= 0 {
+		return protoValue{}, 0, fmt.Errorf("proto: bad tag")
+	}
+	v := protoValue{number: tag >> 3, wire: byte(tag & 7)}
+	if v.number == 0 {
+		return protoValue{}, 0, fmt.Errorf("proto: field number 0")
+	}
+	rest := data[n:]
+	switch v.wire {
+	case protoVarint:
+		bits, m := binary.Uvarint(rest)
+		if m <= 0 {
+			return protoValue{}, 0, fmt.Errorf("proto: field %d: bad varint", v.number)
+		}
+		v.bits = bits
+		return v, n + m, nil
+	case protoFixed64:
+		if len(rest) < 8 {
+			return protoValue{}, 0, fmt.Errorf("proto: field %d: truncated", v.number)
+		}
+		v.bits = binary.LittleEndian.Uint64(rest)
+		return v, n + 8, nil
+	case protoFixed32:
+		if len(rest) < 4 {
+			return protoValue{}, 0, fmt.Errorf("proto: field %d: truncated", v.number)
+		}
+		v.bits = uint64(binary.LittleEndian.Uint32(rest))
+		return v, n + 4, nil
+	case protoBytes:
+		size, m := binary.Uvarint(rest)
+		if m <= 0 || size > uint64(len(rest)-m) {
+			return protoValue{}, 0, fmt.Errorf("proto: field %d: bad length", v.number)
+		}
+		v.data = rest[m : m+int(size)]
+		return v, n + m + int(size), nil
+	case protoStartGroup:
+		m, err := skipProtoGroup(rest, v.number, depth+1)
+		if err != nil {
+			return protoValue{}, 0, err
+		}
+		return v, n + m, nil
+	case protoEndGroup:
+		return protoValue{}, 0, fmt.Errorf("proto: field %d: unexpected end group", v.number)
+	}
+	return protoValue{}, 0, fmt.Errorf("proto: field %d: unsupported wire type %d", v.number, v.wire)
+}
+
+// skipProtoGroup returns the length of the body of group number, up to and
+// including its end-group tag. Nested groups are skipped whole.
+func skipProtoGroup(data []byte, number uint64, depth int) (int, error) {
+	if depth > protoMaxDepth {
+		return 0, fmt.Errorf("proto: groups nested deeper than %d", protoMaxDepth)
+	}
+	off := 0
+	for {
+		tag, n := binary.Uvarint(data[off:])
+		if n <= 0 {
+			return 0, fmt.Errorf("proto: group %d: unterminated", number)
+		}
+		if tag&7 == protoEndGroup {
+			if tag>>3 != number {
+				return 0, fmt.Errorf("proto: group %d: ended by field %d", number, tag>>3)
+			}
+			return off + n, nil
+		}
+		_, m, err := protoField(data[off:], depth)
+		if err != nil {
+			return 0, err
+		}
+		off += m
+	}
+}
+
+// protoFieldIndex returns the userFields index of a known field, or -1 for
+// unknown fields and known numbers with an unexpected wire type.
+func protoFieldIndex(v protoValue) int {
+	i := int(v.number) - 1
+	if v.number > uint64(len(protoFields)) || protoFields[i].wire != v.wire {
+		return -1
+	}
+	return i
+}
+
+func protoInt32(v protoValue, what string) (int32, error) {
+	n := unzigzag(v.bits)
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return 0, fmt.Errorf("proto: %s: %d overflows int32", what, n)
+	}
+	return int32(n), nil
+}
+
+func setProtoField(user *UserInfo, i int, v protoValue) (err error) {
+	switch userFields[i].name {
+	case "id":
+		user.ID = unzigzag(v.bits)
+	case "username":
+		user.Username = string(v.data)
+	case "email":
+		user.Email = string(v.data)
+	case "first_name":
+		user.FirstName = string(v.data)
+	case "last_name":
+		user.LastName = string(v.data)
+	case "age":
+		user.Age, err = protoInt32(v, "age")
+	case "height":
+		user.Height = math.Float32frombits(uint32(v.bits))
+	case "weight":
+		user.Weight = math.Float32frombits(uint32(v.bits))
+	case "balance":
+		user.Balance = math.Float64frombits(v.bits)
+	case "is_active":
+		user.IsActive = v.bits != 0
+	case "created_at":
+		user.CreatedAt = unzigzag(v.bits)
+	case "updated_at":
+		user.UpdatedAt = unzigzag(v.bits)
+	case "login_count":
+		user.LoginCount, err = protoInt32(v, "login_count")
+	case "score":
+		user.Score = math.Float64frombits(v.bits)
+	case "description":
+		user.Description = string(v.data)
+	}
+	return err
+}
+
+func (s *ProtoWireStrategy) decodeProto(data []byte) (*UserInfo, error) {
+	user := &UserInfo{}
+	for len(data) > 0 {
+		v, n, err := nextProtoField(data)
+		if err != nil {
+			return nil, err
+		}
+		if i := protoFieldIndex(v); i >= 0 {
+			if err := setProtoField(user, i, v); err != nil {
+				return nil, err
+			}
+		}
+		data = data[n:]
+	}
+	return user, nil
+}
+
+// protoNumber reads the numeric field at userFields[idx] of a message.
+func protoNumber(data []byte, idx int) (float64, error) {
+	var user UserInfo
+	for len(data) > 0 {
+		v, n, err := nextProtoField(data)
+		if err != nil {
+			return 0, err
+		}
+		if protoFieldIndex(v) == idx {
+			if err := setProtoField(&user, idx, v); err != nil {
+				return 0, err
+			}
+		}
+		data = data[n:]
+	}
+	return userFieldNumber(&user, userFields[idx].name)
+}
+
+// replaceProtoField returns a copy of data with every occurrence of
+// userFields[idx] replaced by field, which takes the place of the first one.
+// The bytes of all other fields, unknown ones included, are kept as they are.
+func replaceProtoField(data []byte, idx int, field []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data)+len(field))
+	placed := false
+	for len(data) > 0 {
+		v, n, err := nextProtoField(data)
+		if err != nil {
+			return nil, err
+		}
+		if v.number != protoFields[idx].number {
+			out = append(out, data[:n]...)
+		} else if !placed {
+			out = append(out, field...)
+			placed = true
+		}
+		data = data[n:]
+	}
+	if !placed {
+		out = append(out, field...)
+	}
+	return out, nil
+}
+
+func (s *ProtoWireStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_proto"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(user.ID))
+		return b.Put(key, s.encodeProto(user))
+	})
+}
+
+func (s *ProtoWireStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_proto"))
+		for _, user := range users {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(user.ID))
+			if err := b.Put(key, s.encodeProto(user)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ProtoWireStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	var user *UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_proto"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		var err error
+		user, err = s.decodeProto(data)
+		return err
+	})
+	return user, err
+}
+
+func (s *ProtoWireStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	var users []*UserInfo
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_proto")).Cursor()
+
+		startKey := make([]byte, 8)
+		binary.BigEndian.PutUint64(startKey, uint64(startId))
+
+		for k, v := c.Seek(startKey); k != nil && len(users) < count; k, v = c.Next() {
+			user, err := s.decodeProto(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *ProtoWireStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	idx, ok := lookupUserField(fieldName)
+	if !ok {
+		return fmt.Errorf("field %q is not updatable", fieldName)
+	}
+	var patch UserInfo
+	if err := setUserField(&patch, fieldName, value); err != nil {
+		return err
+	}
+	field := appendProtoField(nil, idx, &patch)
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("users_proto"))
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(id))
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		newData, err := replaceProtoField(data, idx, field)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, newData)
+	})
+}
+
+func (s *ProtoWireStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	if _, err := userFieldNumber(&UserInfo{}, fieldName); err != nil {
+		return 0, err
+	}
+	idx, _ := lookupUserField(fieldName)
+	var sum float64
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte("users_proto")).Cursor()
+		processed := 0
+		for k, v := c.First(); k != nil && processed < count; k, v = c.Next() {
+			n, err := protoNumber(v, idx)
+			if err != nil {
+				return err
+			}
+			sum += n
+			processed++
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (s *ProtoWireStrategy) encodeValue(user *UserInfo) ([]byte, error) {
+	return s.encodeProto(user), nil
+}
+
+func (s *ProtoWireStrategy) decodeValue(data []byte) (*UserInfo, error) {
+	return s.decodeProto(data)
+}
+
+func (s *ProtoWireStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	return verifyByRead[UserInfo](db, s, []byte("users_proto"), corrupt)
+}
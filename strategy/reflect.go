@@ -0,0 +1,131 @@
+package strategy
+
+import (
+	"fmt"
+	"go.etcd.io/bbolt"
+	"sync"
+)
+
+// 19. Reflection strategy
+//
+// Stores UserInfo through the generic stores and their StructCodec instead
+// of hand-written field code, in one of the layouts the codec derives. It
+// measures what the generic codec costs against the hand-written strategies
+// with the same layout.
+type ReflectStrategy struct {
+	Layout Layout
+
+	once  sync.Once
+	inner Store[UserInfo]
+	err   error
+}
+
+// Layout selects how a StructCodec stores a record.
+type Layout int
+
+const (
+	LayoutBinary Layout = iota
+	LayoutBinaryNames
+	LayoutMultiKV
+	LayoutNestedBucket
+)
+
+func (l Layout) String() string {
+	switch l {
+	case LayoutBinary:
+		return "Binary"
+	case LayoutBinaryNames:
+		return "Binary+Names"
+	case LayoutMultiKV:
+		return "MultiKV"
+	case LayoutNestedBucket:
+		return "NestedBucket"
+	}
+	return fmt.Sprintf("Layout(%d)", int(l))
+}
+
+func (s *ReflectStrategy) Name() string { return "Reflect(" + s.Layout.String() + ")" }
+
+// store builds the generic store for the layout. The ID comes from the
+// codec too, so every field access goes through reflection.
+func (s *ReflectStrategy) store() (Store[UserInfo], error) {
+	s.once.Do(func() {
+		switch s.Layout {
+		case LayoutBinary:
+			s.inner, s.err = NewBinaryStore[UserInfo]("users_reflect_binary", nil)
+		case LayoutBinaryNames:
+			s.inner, s.err = NewBinaryNamesStore[UserInfo]("users_reflect_names", nil)
+		case LayoutMultiKV:
+			s.inner, s.err = NewMultiKVStore[UserInfo]("users_reflect_multikv", nil)
+		case LayoutNestedBucket:
+			s.inner, s.err = NewNestedBucketStore[UserInfo]("users_reflect_nested", nil)
+		default:
+			s.err = fmt.Errorf("unknown layout %v", s.Layout)
+		}
+	})
+	return s.inner, s.err
+}
+
+func (s *ReflectStrategy) Setup(db *bbolt.DB) error {
+	inner, err := s.store()
+	if err != nil {
+		return err
+	}
+	return inner.Setup(db)
+}
+
+func (s *ReflectStrategy) Write(db *bbolt.DB, user *UserInfo) error {
+	inner, err := s.store()
+	if err != nil {
+		return err
+	}
+	return inner.Write(db, user)
+}
+
+func (s *ReflectStrategy) WriteMany(db *bbolt.DB, users []*UserInfo) error {
+	inner, err := s.store()
+	if err != nil {
+		return err
+	}
+	return inner.WriteMany(db, users)
+}
+
+func (s *ReflectStrategy) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+	inner, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	return inner.Read(db, id)
+}
+
+func (s *ReflectStrategy) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+	inner, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+	return inner.ReadMany(db, startId, count)
+}
+
+func (s *ReflectStrategy) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+	inner, err := s.store()
+	if err != nil {
+		return err
+	}
+	return inner.UpdateField(db, id, fieldName, value)
+}
+
+func (s *ReflectStrategy) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+	inner, err := s.store()
+	if err != nil {
+		return 0, err
+	}
+	return inner.ReadFieldSum(db, fieldName, count)
+}
+
+func (s *ReflectStrategy) Verify(db *bbolt.DB, corrupt func(id int64, err error)) error {
+	inner, err := s.store()
+	if err != nil {
+		return err
+	}
+	return Verify(db, inner, corrupt)
+}
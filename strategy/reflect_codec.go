@@ -0,0 +1,594 @@
+package strategy
+
+import (
+	"boltdb_benchmarks/strategy/fieldcodec"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// StructCodec encodes one struct type in the layouts of the hand-written
+// strategies, driven by struct tags instead of hard-coded fields:
+//
+//   - Binary: the ID, then the strings in field order, then the fixed-size
+//     fields in field order, as in BinaryStrategy.
+//   - Binary+Names: a count, then name, type tag and value for every field,
+//     as in BinaryWithNamesStrategy.
+//   - MultiKV and NestedBucket: one key per field, named and encoded with
+//     fieldcodec as in MultiKVStrategy and NestedBucketStrategy.
+//
+// A field's name comes from its `bolt` tag, falling back to its `json` tag
+// and then to the Go name; "-" skips the field. The ID is the field tagged
+// `bolt:",id"`, or else the one named "id", and must be an integer; types
+// without one need an ID accessor, see the generic stores. Supported kinds
+// are bool, the sized integers, float32, float64, string and []byte. The
+// plan for each type is built once and cached.
+type StructCodec struct {
+	typ    reflect.Type
+	fields []codecField
+	binary []int // field indexes in Binary order
+	id     int   // -1 if the type has no ID field
+}
+
+type codecField struct {
+	name  string
+	index int // in the struct
+	kind  reflect.Kind
+	tag   byte // Binary+Names type tag
+	size  int  // fixed width, or 0 for strings and []byte
+}
+
+// Type tags for the integer widths UserInfo does not use.
+const (
+	tagInt16 = byte(7)
+	tagInt8  = byte(8)
+)
+
+var structCodecs sync.Map // reflect.Type → *StructCodec
+
+// CodecFor returns the codec of a struct type or of a pointer to one.
+func CodecFor(typ reflect.Type) (*StructCodec, error) {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if c, ok := structCodecs.Load(typ); ok {
+		return c.(*StructCodec), nil
+	}
+	c, err := newStructCodec(typ)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := structCodecs.LoadOrStore(typ, c)
+	return actual.(*StructCodec), nil
+}
+
+func newStructCodec(typ reflect.Type) (*StructCodec, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("codec: %s is not a struct", typ)
+	}
+	c := &StructCodec{typ: typ, id: -1}
+	idByName := -1
+	seen := map[string]bool{}
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		name, opts := fieldName(sf)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("codec: %s: duplicate field name %q", typ, name)
+		}
+		seen[name] = true
+
+		f := codecField{name: name, index: i, kind: sf.Type.Kind()}
+		switch f.kind {
+		case reflect.Int64, reflect.Uint64:
+			f.tag, f.size = tagInt64, 8
+		case reflect.Int32, reflect.Uint32:
+			f.tag, f.size = tagInt32, 4
+		case reflect.Int16, reflect.Uint16:
+			f.tag, f.size = tagInt16, 2
+		case reflect.Int8, reflect.Uint8:
+			f.tag, f.size = tagInt8, 1
+		case reflect.Float64:
+			f.tag, f.size = tagFloat64, 8
+		case reflect.Float32:
+			f.tag, f.size = tagFloat32, 4
+		case reflect.Bool:
+			f.tag, f.size = tagBool, 1
+		case reflect.String:
+			f.tag = tagString
+		case reflect.Slice:
+			if sf.Type.Elem().Kind() != reflect.Uint8 {
+				return nil, fmt.Errorf("codec: %s.%s: unsupported type %s", typ, sf.Name, sf.Type)
+			}
+			f.tag = tagString
+		default:
+			return nil, fmt.Errorf("codec: %s.%s: unsupported type %s", typ, sf.Name, sf.Type)
+		}
+
+		if opts == "id" {
+			c.id = len(c.fields)
+		} else if name == "id" {
+			idByName = len(c.fields)
+		}
+		c.fields = append(c.fields, f)
+	}
+	if c.id < 0 {
+		c.id = idByName
+	}
+	if c.id >= 0 {
+		if !c.fields[c.id].isInt() {
+			return nil, fmt.Errorf("codec: %s: id field %s is not an integer", typ, c.fields[c.id].name)
+		}
+		c.binary = append(c.binary, c.id)
+	}
+	for i, f := range c.fields {
+		if i != c.id && f.size == 0 {
+			c.binary = append(c.binary, i)
+		}
+	}
+	for i, f := range c.fields {
+		if i != c.id && f.size != 0 {
+			c.binary = append(c.binary, i)
+		}
+	}
+	return c, nil
+}
+
+// fieldName returns the stored name of a struct field and the options of
+// its `bolt` tag, or "" if the field is not stored.
+func fieldName(sf reflect.StructField) (name, opts string) {
+	if !sf.IsExported() {
+		return "", ""
+	}
+	name, opts, _ = strings.Cut(sf.Tag.Get("bolt"), ",")
+	if name == "" {
+		name, _, _ = strings.Cut(sf.Tag.Get("json"), ",")
+	}
+	if name == "-" {
+		return "", ""
+	}
+	if name == "" {
+		name = sf.Name
+	}
+	return name, opts
+}
+
+func (f *codecField) isInt() bool {
+	return f.kind >= reflect.Int && f.kind <= reflect.Uint64
+}
+
+func (f *codecField) isUint() bool {
+	return f.kind >= reflect.Uint && f.kind <= reflect.Uint64
+}
+
+// structValue returns the struct v points to.
+func (c *StructCodec) structValue(v interface{}) reflect.Value {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Type() != c.typ {
+		panic(fmt.Sprintf("codec: want *%s, got %T", c.typ, v))
+	}
+	return rv.Elem()
+}
+
+// Fields returns the field names in struct order.
+func (c *StructCodec) Fields() []string {
+	names := make([]string, len(c.fields))
+	for i := range c.fields {
+		names[i] = c.fields[i].name
+	}
+	return names
+}
+
+func (c *StructCodec) lookup(name string) (int, bool) {
+	for i := range c.fields {
+		if c.fields[i].name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// HasID reports whether the type has an ID field.
+func (c *StructCodec) HasID() bool { return c.id >= 0 }
+
+// ID returns the ID of the struct v points to. It panics if the type has
+// no ID field.
+func (c *StructCodec) ID(v interface{}) int64 {
+	if c.id < 0 {
+		panic(fmt.Sprintf("codec: %s has no id field", c.typ))
+	}
+	fv := c.structValue(v).Field(c.fields[c.id].index)
+	if c.fields[c.id].isUint() {
+		return int64(fv.Uint())
+	}
+	return fv.Int()
+}
+
+// appendFixed writes a fixed-size field little-endian.
+func (f *codecField) appendFixed(buf []byte, fv reflect.Value) []byte {
+	var bits uint64
+	switch {
+	case f.kind == reflect.Bool:
+		if fv.Bool() {
+			bits = 1
+		}
+	case f.kind == reflect.Float32:
+		bits = uint64(math.Float32bits(float32(fv.Float())))
+	case f.kind == reflect.Float64:
+		bits = math.Float64bits(fv.Float())
+	case f.isUint():
+		bits = fv.Uint()
+	default:
+		bits = uint64(fv.Int())
+	}
+	for i := 0; i < f.size; i++ {
+		buf = append(buf, byte(bits>>(8*i)))
+	}
+	return buf
+}
+
+// setFixed reads a fixed-size field written by appendFixed.
+func (f *codecField) setFixed(fv reflect.Value, data []byte) {
+	var bits uint64
+	for i := f.size - 1; i >= 0; i-- {
+		bits = bits<<8 | uint64(data[i])
+	}
+	switch {
+	case f.kind == reflect.Bool:
+		fv.SetBool(bits != 0)
+	case f.kind == reflect.Float32:
+		fv.SetFloat(float64(math.Float32frombits(uint32(bits))))
+	case f.kind == reflect.Float64:
+		fv.SetFloat(math.Float64frombits(bits))
+	case f.isUint():
+		fv.SetUint(bits)
+	default:
+		// Sign-extend from the field width.
+		shift := 64 - 8*f.size
+		fv.SetInt(int64(bits<<shift) >> shift)
+	}
+}
+
+func (f *codecField) bytes(fv reflect.Value) []byte {
+	if f.kind == reflect.String {
+		return []byte(fv.String())
+	}
+	return fv.Bytes()
+}
+
+func (f *codecField) setBytes(fv reflect.Value, data []byte) {
+	if f.kind == reflect.String {
+		fv.SetString(string(data))
+	} else {
+		fv.SetBytes(append([]byte(nil), data...))
+	}
+}
+
+// appendPacked writes a field so that others can follow it: strings get an
+// int32 length prefix.
+func (f *codecField) appendPacked(buf []byte, fv reflect.Value) []byte {
+	if f.size != 0 {
+		return f.appendFixed(buf, fv)
+	}
+	data := f.bytes(fv)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+// readPacked reads a field written by appendPacked and returns the rest.
+func (f *codecField) readPacked(fv reflect.Value, data []byte) ([]byte, error) {
+	size := f.size
+	if size == 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("codec: read %s length: truncated", f.name)
+		}
+		size = int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if size < 0 || size > len(data) {
+			return nil, fmt.Errorf("codec: read %s: length %d exceeds %d remaining bytes", f.name, size, len(data))
+		}
+		f.setBytes(fv, data[:size])
+	} else {
+		if len(data) < size {
+			return nil, fmt.Errorf("codec: read %s: truncated", f.name)
+		}
+		if f.kind == reflect.Bool && data[0] > 1 {
+			return nil, fmt.Errorf("codec: read %s: %w", f.name, ErrBadBool)
+		}
+		f.setFixed(fv, data)
+	}
+	return data[size:], nil
+}
+
+// AppendBinary appends the Binary layout of the struct v points to.
+func (c *StructCodec) AppendBinary(buf []byte, v interface{}) []byte {
+	sv := c.structValue(v)
+	for _, i := range c.binary {
+		f := &c.fields[i]
+		buf = f.appendPacked(buf, sv.Field(f.index))
+	}
+	return buf
+}
+
+// DecodeBinary decodes the Binary layout into the struct v points to.
+func (c *StructCodec) DecodeBinary(data []byte, v interface{}) error {
+	sv := c.structValue(v)
+	for _, i := range c.binary {
+		f := &c.fields[i]
+		var err error
+		if data, err = f.readPacked(sv.Field(f.index), data); err != nil {
+			return err
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("codec: %d trailing bytes", len(data))
+	}
+	return nil
+}
+
+// AppendBinaryNames appends the Binary+Names layout of the struct v points
+// to.
+func (c *StructCodec) AppendBinaryNames(buf []byte, v interface{}) []byte {
+	sv := c.structValue(v)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c.fields)))
+	for i := range c.fields {
+		f := &c.fields[i]
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(f.name)))
+		buf = append(buf, f.name...)
+		buf = append(buf, f.tag)
+		buf = f.appendPacked(buf, sv.Field(f.index))
+	}
+	return buf
+}
+
+// DecodeBinaryNames decodes the Binary+Names layout into the struct v
+// points to. Unknown names are an error, since their size is not known
+// for every tag.
+func (c *StructCodec) DecodeBinaryNames(data []byte, v interface{}) error {
+	sv := c.structValue(v)
+	if len(data) < 4 {
+		return fmt.Errorf("codec: read field count: truncated")
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	for n := uint32(0); n < count; n++ {
+		if len(data) < 4 {
+			return fmt.Errorf("codec: read name length: truncated")
+		}
+		nameLen := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if nameLen < 0 || nameLen >= len(data) {
+			return fmt.Errorf("codec: read name: truncated")
+		}
+		name, tag := data[:nameLen], data[nameLen]
+		data = data[nameLen+1:]
+
+		i, ok := c.lookup(string(name))
+		if !ok {
+			return fmt.Errorf("codec: unknown field %q", name)
+		}
+		f := &c.fields[i]
+		if tag != f.tag {
+			return fmt.Errorf("codec: field %s: type tag %d, want %d", f.name, tag, f.tag)
+		}
+		var err error
+		if data, err = f.readPacked(sv.Field(f.index), data); err != nil {
+			return err
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("codec: %d trailing bytes", len(data))
+	}
+	return nil
+}
+
+// kvValue encodes a field for the MultiKV and NestedBucket layouts. The
+// integer widths fieldcodec lacks are stored little-endian like the others.
+func (f *codecField) kvValue(fv reflect.Value) []byte {
+	switch f.kind {
+	case reflect.Int32:
+		return fieldcodec.AppendInt32(nil, int32(fv.Int()))
+	case reflect.Int64:
+		return fieldcodec.AppendInt64(nil, fv.Int())
+	case reflect.Float32:
+		return fieldcodec.AppendFloat32(nil, float32(fv.Float()))
+	case reflect.Float64:
+		return fieldcodec.AppendFloat64(nil, fv.Float())
+	case reflect.Bool:
+		return fieldcodec.AppendBool(nil, fv.Bool())
+	case reflect.String:
+		return fieldcodec.AppendString(nil, fv.String())
+	}
+	if f.size != 0 {
+		return f.appendFixed(nil, fv)
+	}
+	return f.bytes(fv)
+}
+
+// setKV decodes a value written by kvValue.
+func (f *codecField) setKV(fv reflect.Value, data []byte) error {
+	var err error
+	switch f.kind {
+	case reflect.Int32:
+		var n int32
+		n, err = fieldcodec.DecodeInt32(data)
+		fv.SetInt(int64(n))
+	case reflect.Int64:
+		var n int64
+		n, err = fieldcodec.DecodeInt64(data)
+		fv.SetInt(n)
+	case reflect.Float32:
+		var x float32
+		x, err = fieldcodec.DecodeFloat32(data)
+		fv.SetFloat(float64(x))
+	case reflect.Float64:
+		var x float64
+		x, err = fieldcodec.DecodeFloat64(data)
+		fv.SetFloat(x)
+	case reflect.Bool:
+		var b bool
+		b, err = fieldcodec.DecodeBool(data)
+		fv.SetBool(b)
+	case reflect.String:
+		fv.SetString(fieldcodec.DecodeString(data))
+	default:
+		if f.size == 0 {
+			f.setBytes(fv, data)
+		} else if len(data) != f.size {
+			err = fmt.Errorf("want %d bytes, got %d", f.size, len(data))
+		} else {
+			f.setFixed(fv, data)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("codec: %s: %w", f.name, err)
+	}
+	return nil
+}
+
+func (c *StructCodec) kvKey(id int64, name string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(id)), name...)
+}
+
+// storedValues calls fn with the MultiKV and NestedBucket encoding of every
+// field of the struct v points to, in struct order.
+func (c *StructCodec) storedValues(v interface{}, fn func(name string, data []byte) error) error {
+	sv := c.structValue(v)
+	for i := range c.fields {
+		f := &c.fields[i]
+		if err := fn(f.name, f.kvValue(sv.Field(f.index))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutMultiKV stores the struct v points to as one key per field, each key
+// being the 8-byte ID followed by the field name.
+func (c *StructCodec) PutMultiKV(b *bbolt.Bucket, id int64, v interface{}) error {
+	return c.storedValues(v, func(name string, data []byte) error {
+		return b.Put(c.kvKey(id, name), data)
+	})
+}
+
+// GetMultiKV loads the fields stored under id by PutMultiKV into the struct
+// v points to. It reports whether any field was found.
+func (c *StructCodec) GetMultiKV(b *bbolt.Bucket, id int64, v interface{}) (bool, error) {
+	prefix := binary.BigEndian.AppendUint64(nil, uint64(id))
+	cur := b.Cursor()
+	found := false
+	for k, val := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, val = cur.Next() {
+		found = true
+		if err := c.SetStored(v, string(k[8:]), val); err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// PutNested stores the struct v points to in a bucket of its own, named by
+// the 8-byte ID, with one key per field.
+func (c *StructCodec) PutNested(root *bbolt.Bucket, id int64, v interface{}) error {
+	c.structValue(v) // check the type before creating the bucket
+	b, err := root.CreateBucketIfNotExists(binary.BigEndian.AppendUint64(nil, uint64(id)))
+	if err != nil {
+		return err
+	}
+	return c.storedValues(v, func(name string, data []byte) error {
+		return b.Put([]byte(name), data)
+	})
+}
+
+// GetNested loads a bucket written by PutNested into the struct v points
+// to.
+func (c *StructCodec) GetNested(b *bbolt.Bucket, v interface{}) error {
+	cur := b.Cursor()
+	for k, val := cur.First(); k != nil; k, val = cur.Next() {
+		if err := c.SetStored(v, string(k), val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStored decodes a MultiKV or NestedBucket value of the named field into
+// the struct v points to. Unknown fields are ignored.
+func (c *StructCodec) SetStored(v interface{}, name string, data []byte) error {
+	i, ok := c.lookup(name)
+	if !ok {
+		return nil
+	}
+	f := &c.fields[i]
+	return f.setKV(c.structValue(v).Field(f.index), data)
+}
+
+// StoredValue returns the MultiKV and NestedBucket encoding of value for the
+// named field. value must have the field's exact Go type, and the ID field
+// is not updatable.
+func (c *StructCodec) StoredValue(name string, value interface{}) ([]byte, error) {
+	fv, f, err := c.typedValue(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return f.kvValue(fv), nil
+}
+
+func (c *StructCodec) typedValue(name string, value interface{}) (reflect.Value, *codecField, error) {
+	i, ok := c.lookup(name)
+	if !ok || i == c.id {
+		return reflect.Value{}, nil, fmt.Errorf("field %q is not updatable", name)
+	}
+	f := &c.fields[i]
+	fv, err := typedFieldValue(c.typ.Field(f.index), name, value)
+	return fv, f, err
+}
+
+// typedFieldValue checks that value has the exact type of field sf.
+func typedFieldValue(sf reflect.StructField, name string, value interface{}) (reflect.Value, error) {
+	fv := reflect.ValueOf(value)
+	if !fv.IsValid() || fv.Type() != sf.Type {
+		return reflect.Value{}, fmt.Errorf("%s: unexpected value type %T", name, value)
+	}
+	return fv, nil
+}
+
+// SetField assigns value to the named field of the struct v points to.
+// value must have the field's exact Go type, and the ID field is not
+// updatable.
+func (c *StructCodec) SetField(v interface{}, name string, value interface{}) error {
+	fv, f, err := c.typedValue(name, value)
+	if err != nil {
+		return err
+	}
+	c.structValue(v).Field(f.index).Set(fv)
+	return nil
+}
+
+// FieldNumber returns a numeric field of the struct v points to as float64.
+func (c *StructCodec) FieldNumber(v interface{}, name string) (float64, error) {
+	i, ok := c.lookup(name)
+	if !ok {
+		return 0, fmt.Errorf("cannot sum field %q", name)
+	}
+	return numberValue(c.structValue(v).Field(c.fields[i].index), name)
+}
+
+// numberValue returns a numeric value as float64.
+func numberValue(fv reflect.Value, name string) (float64, error) {
+	switch fv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), nil
+	}
+	return 0, fmt.Errorf("cannot sum field %q", name)
+}
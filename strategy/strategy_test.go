@@ -0,0 +1,93 @@
+package strategy_test
+
+import (
+	"testing"
+
+	. "boltdb_benchmarks/strategy"
+	"boltdb_benchmarks/strategy/conformance"
+)
+
+type conformanceCase struct {
+	new  func() StorageStrategy
+	opts conformance.Options
+}
+
+func TestConformance(t *testing.T) {
+	key, err := NewEphemeralKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pii := []string{"email", "first_name", "last_name"}
+
+	strategies := []conformanceCase{
+		{new: func() StorageStrategy { return &JSONStrategy{} }},
+		{new: func() StorageStrategy { return &JSONArrayStrategy{} }},
+		{new: func() StorageStrategy { return &GOBStrategy{} }},
+		{new: func() StorageStrategy { return &GOBPreambleStrategy{} }},
+		{new: func() StorageStrategy { return &MsgPackStrategy{} }},
+		{new: func() StorageStrategy { return &MsgPackStrategy{ArrayMode: true} }},
+		{new: func() StorageStrategy { return &CBORStrategy{} }},
+		{new: func() StorageStrategy { return &CBORStrategy{IntKeys: true} }},
+		{new: func() StorageStrategy { return &ProtoWireStrategy{} }},
+		{new: func() StorageStrategy { return &AvroStrategy{} }},
+		{new: func() StorageStrategy { return &BinaryStrategy{} }},
+		{new: func() StorageStrategy { return &GeneratedBinaryStrategy{} }},
+		{new: func() StorageStrategy { return &CompactBinaryStrategy{} }},
+		{new: func() StorageStrategy { return &FixedOffsetStrategy{} }},
+		{new: func() StorageStrategy { return &FlatViewStrategy{} }},
+		{new: func() StorageStrategy { return &DeltaStrategy{} }},
+		{new: func() StorageStrategy { return &DeltaStrategy{AnchorEvery: 1} }},
+		{new: func() StorageStrategy { return &BinaryWithNamesStrategy{} }},
+		{new: func() StorageStrategy { return &MultiKVStrategy{} }},
+		{new: func() StorageStrategy { return &MultiKVIDStrategy{} }},
+		{new: func() StorageStrategy { return &NestedBucketStrategy{} }},
+		{new: func() StorageStrategy { return &HotColdStrategy{} }},
+		{new: func() StorageStrategy { return &ColumnarStrategy{} }},
+		{new: func() StorageStrategy { return &BlockPackedStrategy{BlockSize: 16} }},
+		{new: func() StorageStrategy { return &BlockPackedStrategy{BlockSize: 1} }},
+		{new: func() StorageStrategy { return &ReflectStrategy{Layout: LayoutBinary} }},
+		{new: func() StorageStrategy { return &ReflectStrategy{Layout: LayoutBinaryNames} }},
+		{new: func() StorageStrategy { return &ReflectStrategy{Layout: LayoutMultiKV} }},
+		{new: func() StorageStrategy { return &ReflectStrategy{Layout: LayoutNestedBucket} }},
+		{new: func() StorageStrategy { return &EncryptedStrategy{Inner: &JSONStrategy{}, Key: key} }},
+		{new: func() StorageStrategy { return &EncryptedStrategy{Inner: &BinaryStrategy{}, Key: key} }},
+		{new: func() StorageStrategy {
+			return &FieldEncryptedStrategy{Layout: LayoutMultiKV, Fields: pii, Key: key}
+		}},
+		{new: func() StorageStrategy {
+			return &FieldEncryptedStrategy{Layout: LayoutNestedBucket, Fields: append(pii, "balance"), Key: key}
+		}},
+	}
+
+	// Every compression and checksum decorator over every value-per-record
+	// strategy
+	for _, inner := range []func() StorageStrategy{
+		func() StorageStrategy { return &JSONStrategy{} },
+		func() StorageStrategy { return &GOBStrategy{} },
+		func() StorageStrategy { return &BinaryStrategy{} },
+		func() StorageStrategy { return &BinaryWithNamesStrategy{} },
+	} {
+		for _, codec := range []func() *Compression{
+			func() *Compression { return FlateCompression(6) },
+			GzipCompression,
+			LZWCompression,
+		} {
+			strategies = append(strategies, conformanceCase{new: func() StorageStrategy {
+				return &CompressedStrategy{Inner: inner(), Codec: codec()}
+			}})
+		}
+		strategies = append(strategies, conformanceCase{new: func() StorageStrategy {
+			return &DictCompressedStrategy{Inner: inner()}
+		}})
+		strategies = append(strategies, conformanceCase{new: func() StorageStrategy {
+			return &ChecksummedStrategy{Inner: inner()}
+		}})
+	}
+
+	for _, s := range strategies {
+		t.Run(s.new().Name(), func(t *testing.T) {
+			t.Parallel()
+			conformance.Run(t, s.new, s.opts)
+		})
+	}
+}
@@ -4,35 +4,37 @@ import (
 	"go.etcd.io/bbolt"
 )
 
-type StrategyVariant struct {
-	Strategy StorageStrategy
+type Variant[T any] struct {
+	Strategy Store[T]
 	Bulk     bool
 }
 
-func (sv *StrategyVariant) Name() string {
+type StrategyVariant = Variant[UserInfo]
+
+func (sv *Variant[T]) Name() string {
 	return sv.Strategy.Name()
 }
 
-func (sv *StrategyVariant) Setup(db *bbolt.DB) error { return sv.Strategy.Setup(db) }
-func (sv *StrategyVariant) Read(db *bbolt.DB, id int64) (*UserInfo, error) {
+func (sv *Variant[T]) Setup(db *bbolt.DB) error { return sv.Strategy.Setup(db) }
+func (sv *Variant[T]) Read(db *bbolt.DB, id int64) (*T, error) {
 	return sv.Strategy.Read(db, id)
 }
-func (sv *StrategyVariant) ReadMany(db *bbolt.DB, startId int64, count int) ([]*UserInfo, error) {
+func (sv *Variant[T]) ReadMany(db *bbolt.DB, startId int64, count int) ([]*T, error) {
 	return sv.Strategy.ReadMany(db, startId, count)
 }
-func (sv *StrategyVariant) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
+func (sv *Variant[T]) UpdateField(db *bbolt.DB, id int64, fieldName string, value interface{}) error {
 	return sv.Strategy.UpdateField(db, id, fieldName, value)
 }
-func (sv *StrategyVariant) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
+func (sv *Variant[T]) ReadFieldSum(db *bbolt.DB, fieldName string, count int) (float64, error) {
 	return sv.Strategy.ReadFieldSum(db, fieldName, count)
 }
 
-func (sv *StrategyVariant) WriteAll(db *bbolt.DB, users []*UserInfo) error {
+func (sv *Variant[T]) WriteAll(db *bbolt.DB, records []*T) error {
 	if sv.Bulk {
-		return sv.Strategy.WriteMany(db, users)
+		return sv.Strategy.WriteMany(db, records)
 	} else {
-		for _, user := range users {
-			if err := sv.Strategy.Write(db, user); err != nil {
+		for _, record := range records {
+			if err := sv.Strategy.Write(db, record); err != nil {
 				return err
 			}
 		}
@@ -41,6 +43,6 @@ func (sv *StrategyVariant) WriteAll(db *bbolt.DB, users []*UserInfo) error {
 }
 
 // WriteMode determines how writes are performed during benchmarks
-func (sv *StrategyVariant) WriteMode() bool {
+func (sv *Variant[T]) WriteMode() bool {
 	return sv.Bulk
 }
@@ -0,0 +1,423 @@
+// Code generated by bingen -type UserInfo; DO NOT EDIT.
+
+package strategy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	userInfoBinaryIDSize    = 8  // bytes before the first string
+	userInfoBinaryFixedSize = 49 // bytes of the fixed-size fields after the strings
+)
+
+// userInfoBinarySize returns the length of the Binary encoding of v.
+func userInfoBinarySize(v *UserInfo) int {
+	n := userInfoBinaryIDSize + 20 + userInfoBinaryFixedSize
+	n += len(v.Username)
+	n += len(v.Email)
+	n += len(v.FirstName)
+	n += len(v.LastName)
+	n += len(v.Description)
+	return n
+}
+
+// appendUserInfoBinary appends the Binary encoding of v to buf.
+func appendUserInfoBinary(buf []byte, v *UserInfo) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(v.ID))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.Username)))
+	buf = append(buf, v.Username...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.Email)))
+	buf = append(buf, v.Email...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.FirstName)))
+	buf = append(buf, v.FirstName...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.LastName)))
+	buf = append(buf, v.LastName...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v.Description)))
+	buf = append(buf, v.Description...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(v.Age))
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v.Height))
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v.Weight))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Balance))
+	if v.IsActive {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(v.CreatedAt))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(v.UpdatedAt))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(v.LoginCount))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Score))
+	return buf
+}
+
+// decodeUserInfoBinary decodes a Binary encoding into v. It checks every length
+// against data and rejects trailing bytes.
+func decodeUserInfoBinary(data []byte, v *UserInfo) error {
+	if len(data) < userInfoBinaryIDSize {
+		return fmt.Errorf("UserInfo: read id: %w", ErrTruncated)
+	}
+	v.ID = int64(binary.LittleEndian.Uint64(data[0:]))
+	data = data[userInfoBinaryIDSize:]
+	var n int64
+	if len(data) < 4 {
+		return fmt.Errorf("UserInfo: read username: %w", ErrTruncated)
+	}
+	n = int64(int32(binary.LittleEndian.Uint32(data)))
+	if n < 0 || n > int64(len(data)-4) {
+		return fmt.Errorf("UserInfo: %w", &LengthError{Field: "username", Length: n, Remaining: len(data) - 4})
+	}
+	v.Username = string(data[4 : 4+n])
+	data = data[4+n:]
+	if len(data) < 4 {
+		return fmt.Errorf("UserInfo: read email: %w", ErrTruncated)
+	}
+	n = int64(int32(binary.LittleEndian.Uint32(data)))
+	if n < 0 || n > int64(len(data)-4) {
+		return fmt.Errorf("UserInfo: %w", &LengthError{Field: "email", Length: n, Remaining: len(data) - 4})
+	}
+	v.Email = string(data[4 : 4+n])
+	data = data[4+n:]
+	if len(data) < 4 {
+		return fmt.Errorf("UserInfo: read first_name: %w", ErrTruncated)
+	}
+	n = int64(int32(binary.LittleEndian.Uint32(data)))
+	if n < 0 || n > int64(len(data)-4) {
+		return fmt.Errorf("UserInfo: %w", &LengthError{Field: "first_name", Length: n, Remaining: len(data) - 4})
+	}
+	v.FirstName = string(data[4 : 4+n])
+	data = data[4+n:]
+	if len(data) < 4 {
+		return fmt.Errorf("UserInfo: read last_name: %w", ErrTruncated)
+	}
+	n = int64(int32(binary.LittleEndian.Uint32(data)))
+	if n < 0 || n > int64(len(data)-4) {
+		return fmt.Errorf("UserInfo: %w", &LengthError{Field: "last_name", Length: n, Remaining: len(data) - 4})
+	}
+	v.LastName = string(data[4 : 4+n])
+	data = data[4+n:]
+	if len(data) < 4 {
+		return fmt.Errorf("UserInfo: read description: %w", ErrTruncated)
+	}
+	n = int64(int32(binary.LittleEndian.Uint32(data)))
+	if n < 0 || n > int64(len(data)-4) {
+		return fmt.Errorf("UserInfo: %w", &LengthError{Field: "description", Length: n, Remaining: len(data) - 4})
+	}
+	v.Description = string(data[4 : 4+n])
+	data = data[4+n:]
+	if len(data) < userInfoBinaryFixedSize {
+		return fmt.Errorf("UserInfo: read fixed fields: %w", ErrTruncated)
+	}
+	if len(data) > userInfoBinaryFixedSize {
+		return fmt.Errorf("UserInfo: %d bytes after the last field: %w", len(data)-userInfoBinaryFixedSize, ErrTrailingData)
+	}
+	if data[20] > 1 {
+		return fmt.Errorf("UserInfo: read is_active: %w", ErrBadBool)
+	}
+	v.Age = int32(binary.LittleEndian.Uint32(data[0:]))
+	v.Height = math.Float32frombits(binary.LittleEndian.Uint32(data[4:]))
+	v.Weight = math.Float32frombits(binary.LittleEndian.Uint32(data[8:]))
+	v.Balance = math.Float64frombits(binary.LittleEndian.Uint64(data[12:]))
+	v.IsActive = data[20] != 0
+	v.CreatedAt = int64(binary.LittleEndian.Uint64(data[21:]))
+	v.UpdatedAt = int64(binary.LittleEndian.Uint64(data[29:]))
+	v.LoginCount = int32(binary.LittleEndian.Uint32(data[37:]))
+	v.Score = math.Float64frombits(binary.LittleEndian.Uint64(data[41:]))
+	return nil
+}
+
+// userInfoStringOffset returns the offset of the length prefix of the i-th
+// variable-size field, or of the fixed-size fields for i == 5.
+func userInfoStringOffset(data []byte, i int) (int, error) {
+	off := userInfoBinaryIDSize
+	if len(data) < off {
+		return 0, fmt.Errorf("UserInfo: %w", ErrTruncated)
+	}
+	for ; i > 0; i-- {
+		if len(data)-off < 4 {
+			return 0, fmt.Errorf("UserInfo: %w", ErrTruncated)
+		}
+		n := int64(int32(binary.LittleEndian.Uint32(data[off:])))
+		if n < 0 || n > int64(len(data)-off-4) {
+			return 0, fmt.Errorf("UserInfo: %w", &LengthError{Field: "string", Length: n, Remaining: len(data) - off - 4})
+		}
+		off += 4 + int(n)
+	}
+	return off, nil
+}
+
+// userInfoFixedOffset returns the offset of the fixed-size fields after the
+// strings, checking that exactly userInfoBinaryFixedSize bytes follow.
+func userInfoFixedOffset(data []byte) (int, error) {
+	off, err := userInfoStringOffset(data, 5)
+	if err != nil {
+		return 0, err
+	}
+	if len(data)-off < userInfoBinaryFixedSize {
+		return 0, fmt.Errorf("UserInfo: read fixed fields: %w", ErrTruncated)
+	}
+	if len(data)-off > userInfoBinaryFixedSize {
+		return 0, fmt.Errorf("UserInfo: %d bytes after the last field: %w", len(data)-off-userInfoBinaryFixedSize, ErrTrailingData)
+	}
+	return off, nil
+}
+
+// userInfoIDOffset returns the offset of ID in a Binary encoding.
+func userInfoIDOffset(data []byte) (int, error) {
+	if len(data) < userInfoBinaryIDSize {
+		return 0, fmt.Errorf("UserInfo: read id: %w", ErrTruncated)
+	}
+	return 0, nil
+}
+
+// userInfoUsernameOffset returns the offset of the length prefix of Username.
+func userInfoUsernameOffset(data []byte) (int, error) { return userInfoStringOffset(data, 0) }
+
+// userInfoEmailOffset returns the offset of the length prefix of Email.
+func userInfoEmailOffset(data []byte) (int, error) { return userInfoStringOffset(data, 1) }
+
+// userInfoFirstNameOffset returns the offset of the length prefix of FirstName.
+func userInfoFirstNameOffset(data []byte) (int, error) { return userInfoStringOffset(data, 2) }
+
+// userInfoLastNameOffset returns the offset of the length prefix of LastName.
+func userInfoLastNameOffset(data []byte) (int, error) { return userInfoStringOffset(data, 3) }
+
+// userInfoDescriptionOffset returns the offset of the length prefix of Description.
+func userInfoDescriptionOffset(data []byte) (int, error) { return userInfoStringOffset(data, 4) }
+
+// userInfoAgeOffset returns the offset of Age in a Binary encoding.
+func userInfoAgeOffset(data []byte) (int, error) { return userInfoFixedOffset(data) }
+
+// userInfoHeightOffset returns the offset of Height in a Binary encoding.
+func userInfoHeightOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 4, err
+}
+
+// userInfoWeightOffset returns the offset of Weight in a Binary encoding.
+func userInfoWeightOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 8, err
+}
+
+// userInfoBalanceOffset returns the offset of Balance in a Binary encoding.
+func userInfoBalanceOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 12, err
+}
+
+// userInfoIsActiveOffset returns the offset of IsActive in a Binary encoding.
+func userInfoIsActiveOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 20, err
+}
+
+// userInfoCreatedAtOffset returns the offset of CreatedAt in a Binary encoding.
+func userInfoCreatedAtOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 21, err
+}
+
+// userInfoUpdatedAtOffset returns the offset of UpdatedAt in a Binary encoding.
+func userInfoUpdatedAtOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 29, err
+}
+
+// userInfoLoginCountOffset returns the offset of LoginCount in a Binary encoding.
+func userInfoLoginCountOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 37, err
+}
+
+// userInfoScoreOffset returns the offset of Score in a Binary encoding.
+func userInfoScoreOffset(data []byte) (int, error) {
+	off, err := userInfoFixedOffset(data)
+	return off + 41, err
+}
+
+// setUserInfoField assigns value to the named field; value must have the
+// field's exact type. The ID, which is also the key, is not updatable.
+func setUserInfoField(v *UserInfo, name string, value interface{}) error {
+	var ok bool
+	switch name {
+	case "username":
+		v.Username, ok = value.(string)
+	case "email":
+		v.Email, ok = value.(string)
+	case "first_name":
+		v.FirstName, ok = value.(string)
+	case "last_name":
+		v.LastName, ok = value.(string)
+	case "description":
+		v.Description, ok = value.(string)
+	case "age":
+		v.Age, ok = value.(int32)
+	case "height":
+		v.Height, ok = value.(float32)
+	case "weight":
+		v.Weight, ok = value.(float32)
+	case "balance":
+		v.Balance, ok = value.(float64)
+	case "is_active":
+		v.IsActive, ok = value.(bool)
+	case "created_at":
+		v.CreatedAt, ok = value.(int64)
+	case "updated_at":
+		v.UpdatedAt, ok = value.(int64)
+	case "login_count":
+		v.LoginCount, ok = value.(int32)
+	case "score":
+		v.Score, ok = value.(float64)
+	default:
+		return fmt.Errorf("field %q is not updatable", name)
+	}
+	if !ok {
+		return fmt.Errorf("%s: unexpected value type %T", name, value)
+	}
+	return nil
+}
+
+// isUserInfoNumber reports whether the named field is numeric and can be summed.
+func isUserInfoNumber(name string) bool {
+	switch name {
+	case "id", "age", "height", "weight", "balance", "created_at", "updated_at", "login_count", "score":
+		return true
+	}
+	return false
+}
+
+// readUserInfoNumber reads a numeric field as float64 straight from a Binary
+// encoding, without decoding the rest of the record.
+func readUserInfoNumber(data []byte, name string) (float64, error) {
+	switch name {
+	case "id":
+		off, err := userInfoIDOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return float64(int64(binary.LittleEndian.Uint64(data[off:]))), nil
+	case "age":
+		off, err := userInfoAgeOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return float64(int32(binary.LittleEndian.Uint32(data[off:]))), nil
+	case "height":
+		off, err := userInfoHeightOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off:]))), nil
+	case "weight":
+		off, err := userInfoWeightOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off:]))), nil
+	case "balance":
+		off, err := userInfoBalanceOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data[off:])), nil
+	case "created_at":
+		off, err := userInfoCreatedAtOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return float64(int64(binary.LittleEndian.Uint64(data[off:]))), nil
+	case "updated_at":
+		off, err := userInfoUpdatedAtOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return float64(int64(binary.LittleEndian.Uint64(data[off:]))), nil
+	case "login_count":
+		off, err := userInfoLoginCountOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return float64(int32(binary.LittleEndian.Uint32(data[off:]))), nil
+	case "score":
+		off, err := userInfoScoreOffset(data)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data[off:])), nil
+	}
+	return 0, fmt.Errorf("cannot sum field %q", name)
+}
+
+// patchUserInfoField returns a copy of a Binary encoding with a fixed-size field
+// set to value, leaving data untouched. It reports false for fields whose
+// size can change, which need a full re-encode.
+func patchUserInfoField(data []byte, name string, value interface{}) ([]byte, bool, error) {
+	var v UserInfo
+	var off int
+	var err error
+	switch name {
+	case "id":
+		off, err = userInfoIDOffset(data)
+	case "age":
+		off, err = userInfoAgeOffset(data)
+	case "height":
+		off, err = userInfoHeightOffset(data)
+	case "weight":
+		off, err = userInfoWeightOffset(data)
+	case "balance":
+		off, err = userInfoBalanceOffset(data)
+	case "is_active":
+		off, err = userInfoIsActiveOffset(data)
+	case "created_at":
+		off, err = userInfoCreatedAtOffset(data)
+	case "updated_at":
+		off, err = userInfoUpdatedAtOffset(data)
+	case "login_count":
+		off, err = userInfoLoginCountOffset(data)
+	case "score":
+		off, err = userInfoScoreOffset(data)
+	default:
+		if err := setUserInfoField(&v, name, value); err != nil {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := setUserInfoField(&v, name, value); err != nil {
+		return nil, false, err
+	}
+	buf := make([]byte, off, len(data))
+	copy(buf, data)
+	switch name {
+	case "id":
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v.ID))
+	case "age":
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(v.Age))
+	case "height":
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v.Height))
+	case "weight":
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v.Weight))
+	case "balance":
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Balance))
+	case "is_active":
+		if v.IsActive {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case "created_at":
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v.CreatedAt))
+	case "updated_at":
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v.UpdatedAt))
+	case "login_count":
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(v.LoginCount))
+	case "score":
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Score))
+	}
+	return append(buf, data[len(buf):]...), true, nil
+}
@@ -0,0 +1,24 @@
+package strategy
+
+import "fmt"
+
+// valueCodec is implemented by strategies that store each record as a
+// single value keyed by ID, so that decorators can reuse their encoding.
+type valueCodec interface {
+	StorageStrategy
+	encodeValue(user *UserInfo) ([]byte, error)
+	decodeValue(data []byte) (*UserInfo, error)
+}
+
+// asValueCodec returns the value encoding of a strategy that a decorator
+// wraps, or an error if the strategy does not store one value per record.
+func asValueCodec(inner StorageStrategy) (valueCodec, error) {
+	if inner == nil {
+		return nil, fmt.Errorf("no inner strategy")
+	}
+	codec, ok := inner.(valueCodec)
+	if !ok {
+		return nil, fmt.Errorf("%s does not store one value per record", inner.Name())
+	}
+	return codec, nil
+}
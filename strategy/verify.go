@@ -0,0 +1,92 @@
+package strategy
+
+import (
+	"boltdb_benchmarks/strategy/fieldcodec"
+	"encoding/binary"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// Verifier is implemented by strategies that can check the records they
+// stored.
+type Verifier interface {
+	// Verify reads back every stored record and calls corrupt with the ID
+	// of each one that cannot be read, and why. It returns an error only
+	// if the walk itself fails. It does not write to db, which need not
+	// have been passed to Setup.
+	Verify(db *bbolt.DB, corrupt func(id int64, err error)) error
+}
+
+// Verify checks every record s stored in db; see Verifier.
+func Verify(db *bbolt.DB, s StorageStrategy, corrupt func(id int64, err error)) error {
+	v, ok := s.(Verifier)
+	if !ok {
+		return fmt.Errorf("%s cannot be verified", s.Name())
+	}
+	return v.Verify(db, corrupt)
+}
+
+// verifyByRead reads back through s every record with a key in bucket.
+// Keys start with the record's 8-byte ID; consecutive keys of the same
+// record, as in MultiKV, are read once.
+func verifyByRead[T any](db *bbolt.DB, s Store[T], bucket []byte, corrupt func(id int64, err error)) error {
+	var ids []int64
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucket)
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			if len(k) < 8 {
+				return fmt.Errorf("bucket %s: key %x is not a record ID", bucket, k)
+			}
+			id := int64(binary.BigEndian.Uint64(k))
+			if len(ids) == 0 || ids[len(ids)-1] != id {
+				ids = append(ids, id)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := s.Read(db, id); err != nil {
+			corrupt(id, err)
+		}
+	}
+	return nil
+}
+
+// verifyFieldsByRead is verifyByRead for buckets of fieldcodec values whose
+// version is kept in meta. Like Setup, it returns
+// fieldcodec.ErrLegacyFormat for data in the legacy encoding, but it
+// records no version.
+func verifyFieldsByRead[T any](db *bbolt.DB, s Store[T], bucket, meta []byte, corrupt func(id int64, err error)) error {
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil // verifyByRead reports it
+		}
+		version := uint64(fieldcodec.Version)
+		if m := tx.Bucket(meta); m != nil {
+			var err error
+			if version, err = fieldcodec.ReadVersion(m, b); err != nil {
+				return err
+			}
+		} else if k, _ := b.Cursor().First(); k != nil {
+			version = fieldcodec.LegacyVersion
+		}
+		switch version {
+		case fieldcodec.Version:
+			return nil
+		case fieldcodec.LegacyVersion:
+			return fieldcodec.ErrLegacyFormat
+		}
+		return fmt.Errorf("unsupported format version %d", version)
+	})
+	if err != nil {
+		return err
+	}
+	return verifyByRead(db, s, bucket, corrupt)
+}